@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	avroMagic = "Obj\x01"
+	// avroBlockSize is the number of uncompressed bytes of records gathered into each block.
+	avroBlockSize = 64 * 1024
+)
+
+// avroFormat writes the records of each source as Avro object container files, <out>/<source>.avro,
+// or <out>/<source>.<n>.avro once -rollover is set or an inferred schema widens. Each record is
+// converted as by -format json, without the object keyed by the root name, and then encoded with the
+// schema read from -avro-schema or, when none is given, inferred from the first -avro-sample records
+// of the source:
+//
+//   - child elements and attributes become fields of nested records, named after the element,
+//     attributes prefixed with "_" and text alongside them "_text", with any characters not allowed
+//     in Avro names replaced by "_"
+//   - repeated children, and those listed in -json-arrays, become arrays
+//   - every field is nullable, defaulting to null, as later records may lack it
+//
+// A later record that does not fit an inferred schema, such as one with a field the sample did not
+// have, widens the schema, and it and the records after it are written to a new file with the wider
+// schema. Records that do not fit a supplied schema cause an error rather than losing the field.
+type avroFormat struct {
+	json   *jsonFormat
+	schema *avroSchema
+	// schemaJSON is the supplied schema as written in -avro-schema.
+	schemaJSON []byte
+	sample     int
+	codec      string
+	level      int
+}
+
+func newAvroFormat(conf Config) (*avroFormat, error) {
+	f := &avroFormat{json: newJSONFormat(conf), sample: conf.avroSample, codec: conf.avroCodec, level: conf.compressLevel}
+	switch f.codec {
+	case "null", "deflate":
+	default:
+		return nil, fmt.Errorf("unknown avro codec '%s'", f.codec)
+	}
+	if f.sample < 1 {
+		return nil, errors.New("-avro-sample must be at least 1")
+	}
+	if conf.avroSchema == "" {
+		return f, nil
+	}
+	content, err := ioutil.ReadFile(conf.avroSchema)
+	if err != nil {
+		return nil, err
+	}
+	var definition interface{}
+	if err := json.Unmarshal(content, &definition); err != nil {
+		return nil, fmt.Errorf("%s: %v", conf.avroSchema, err)
+	}
+	if f.schema, err = parseAvroSchema(definition, make(map[string]*avroSchema)); err != nil {
+		return nil, fmt.Errorf("%s: %v", conf.avroSchema, err)
+	}
+	f.schemaJSON = content
+	return f, nil
+}
+
+func (f *avroFormat) streamOnly() {}
+
+func (f *avroFormat) extension() string {
+	return ".avro"
+}
+
+func (f *avroFormat) streamExtension() string {
+	return ".avro"
+}
+
+func (f *avroFormat) render(rec record) ([]byte, error) {
+	return f.renderLine(rec)
+}
+
+// renderLine returns the binary encoding of a single record with the supplied schema. Container
+// files are written by avroWriter, which also handles inferred schemas.
+func (f *avroFormat) renderLine(rec record) ([]byte, error) {
+	if f.schema == nil {
+		return nil, errors.New("records can only be encoded individually with -avro-schema")
+	}
+	var b bytes.Buffer
+	if err := f.schema.encode(&b, f.json.value(rec.root)); err != nil {
+		return nil, fmt.Errorf("%s: %v", rec.key, err)
+	}
+	return b.Bytes(), nil
+}
+
+// avroSchema is the subset of Avro schemas records are encoded with: primitives, records, arrays
+// and unions of null with another type.
+type avroSchema struct {
+	kind string
+	// nullable schemas are unions of null and kind, with null as branch nullIndex.
+	nullable  bool
+	nullIndex int
+	name      string
+	fields    []avroField
+	items     *avroSchema
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+// avroName converts an element or attribute name, as converted to JSON, into a valid Avro name.
+func avroName(name string) string {
+	name = strings.Replace(strings.Replace(name, "@", "_", 1), "#text", "_text", 1)
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// inferAvroSchema infers the schema of a value converted to JSON. Records are named after the path
+// of fields leading to them, so the names are unique within the schema.
+func inferAvroSchema(v interface{}, name string) *avroSchema {
+	switch v := v.(type) {
+	case string:
+		return &avroSchema{kind: "string"}
+	case []interface{}:
+		var items *avroSchema
+		for _, item := range v {
+			items = mergeAvroSchemas(items, inferAvroSchema(item, name))
+		}
+		if items == nil {
+			items = &avroSchema{kind: "null"}
+		}
+		return &avroSchema{kind: "array", items: items}
+	case jsonObject:
+		s := &avroSchema{kind: "record", name: name}
+		for _, field := range v {
+			fieldName := avroName(field.key)
+			schema := inferAvroSchema(field.value, name+"_"+fieldName)
+			schema.nullable = schema.kind != "null"
+			if existing := s.field(fieldName); existing != nil {
+				*existing = *mergeAvroSchemas(existing, schema)
+			} else {
+				s.fields = append(s.fields, avroField{name: fieldName, schema: schema})
+			}
+		}
+		return s
+	}
+	return &avroSchema{kind: "null"}
+}
+
+// mergeAvroSchemas returns a schema that fits the values of both a and b, either of which may be nil.
+func mergeAvroSchemas(a, b *avroSchema) *avroSchema {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.kind == "null" || b.kind == "null" {
+		merged := *a
+		if a.kind == "null" {
+			merged = *b
+		}
+		merged.nullable = merged.kind != "null"
+		return &merged
+	}
+	nullable := a.nullable || b.nullable
+	switch {
+	case a.kind == "array" || b.kind == "array":
+		// Single values are written as an array of one.
+		merged := &avroSchema{kind: "array", nullable: nullable}
+		merged.items = mergeAvroSchemas(arrayItems(a), arrayItems(b))
+		return merged
+	case a.kind == "record" && b.kind == "record":
+		merged := &avroSchema{kind: "record", name: a.name, nullable: nullable}
+		for _, field := range a.fields {
+			merged.fields = append(merged.fields, avroField{name: field.name, schema: mergeAvroSchemas(field.schema, b.field(field.name))})
+		}
+		for _, field := range b.fields {
+			if a.field(field.name) == nil {
+				merged.fields = append(merged.fields, field)
+			}
+		}
+		for i, field := range merged.fields {
+			if a.field(field.name) == nil || b.field(field.name) == nil {
+				schema := *field.schema
+				schema.nullable = schema.kind != "null"
+				merged.fields[i].schema = &schema
+			}
+		}
+		return merged
+	case a.kind == "record" || b.kind == "record":
+		// Text only elements are written as records holding just the text.
+		record, text := a, b
+		if b.kind == "record" {
+			record, text = b, a
+		}
+		merged := mergeAvroSchemas(record, &avroSchema{kind: "record", name: record.name, fields: []avroField{{name: "_text", schema: text}}})
+		merged.nullable = nullable
+		return merged
+	}
+	merged := *a
+	merged.nullable = nullable
+	return &merged
+}
+
+func arrayItems(s *avroSchema) *avroSchema {
+	if s.kind == "array" {
+		return s.items
+	}
+	items := *s
+	items.nullable = false
+	return &items
+}
+
+func (s *avroSchema) field(name string) *avroSchema {
+	for _, field := range s.fields {
+		if field.name == name {
+			return field.schema
+		}
+	}
+	return nil
+}
+
+// definition returns the schema in its JSON form.
+func (s *avroSchema) definition() interface{} {
+	var definition interface{} = s.kind
+	switch s.kind {
+	case "record":
+		var fields []interface{}
+		for _, field := range s.fields {
+			f := jsonObject{{key: "name", value: field.name}, {key: "type", value: field.schema.definition()}}
+			if field.schema.nullable && field.schema.nullIndex == 0 || field.schema.kind == "null" {
+				f = append(f, jsonField{key: "default", value: nil})
+			}
+			fields = append(fields, f)
+		}
+		if fields == nil {
+			fields = []interface{}{}
+		}
+		definition = jsonObject{{key: "type", value: "record"}, {key: "name", value: s.name}, {key: "fields", value: fields}}
+	case "array":
+		definition = jsonObject{{key: "type", value: "array"}, {key: "items", value: s.items.definition()}}
+	}
+	if s.nullable && s.nullIndex == 0 {
+		return []interface{}{"null", definition}
+	} else if s.nullable {
+		return []interface{}{definition, "null"}
+	}
+	return definition
+}
+
+// parseAvroSchema reads a schema from its JSON form. Named records are collected in named so later
+// references to them can be resolved.
+func parseAvroSchema(definition interface{}, named map[string]*avroSchema) (*avroSchema, error) {
+	switch d := definition.(type) {
+	case string:
+		switch d {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{kind: d}, nil
+		}
+		if s, ok := named[d]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type '%s'", d)
+	case []interface{}:
+		if len(d) == 2 {
+			for i, branch := range d {
+				if branch == "null" {
+					s, err := parseAvroSchema(d[1-i], named)
+					if err != nil {
+						return nil, err
+					}
+					nullable := *s
+					nullable.nullable, nullable.nullIndex = true, i
+					return &nullable, nil
+				}
+			}
+		}
+		return nil, errors.New("only unions of null and one other type are supported")
+	case map[string]interface{}:
+		kind, _ := d["type"].(string)
+		switch kind {
+		case "record":
+			name, _ := d["name"].(string)
+			s := &avroSchema{kind: "record", name: name}
+			named[name] = s
+			fields, _ := d["fields"].([]interface{})
+			for _, field := range fields {
+				f, _ := field.(map[string]interface{})
+				fieldName, _ := f["name"].(string)
+				schema, err := parseAvroSchema(f["type"], named)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %v", name, fieldName, err)
+				}
+				s.fields = append(s.fields, avroField{name: fieldName, schema: schema})
+			}
+			return s, nil
+		case "array":
+			items, err := parseAvroSchema(d["items"], named)
+			if err != nil {
+				return nil, err
+			}
+			return &avroSchema{kind: "array", items: items}, nil
+		}
+		return parseAvroSchema(d["type"], named)
+	}
+	return nil, fmt.Errorf("unsupported avro schema %v", definition)
+}
+
+// encode writes the binary encoding of a value converted to JSON.
+func (s *avroSchema) encode(b *bytes.Buffer, v interface{}) error {
+	if s.nullable {
+		if v == nil {
+			writeAvroLong(b, int64(s.nullIndex))
+			return nil
+		}
+		writeAvroLong(b, int64(1-s.nullIndex))
+	}
+	switch s.kind {
+	case "null":
+		if v != nil {
+			return errors.New("unexpected value")
+		}
+		return nil
+	case "record":
+		object, ok := v.(jsonObject)
+		if !ok && v != nil {
+			object = jsonObject{{key: "#text", value: v}}
+		}
+		values := make(map[string]interface{}, len(object))
+		for _, field := range object {
+			name := avroName(field.key)
+			if s.field(name) == nil {
+				return fmt.Errorf("%s: field not in the schema", name)
+			}
+			values[name] = field.value
+		}
+		for _, field := range s.fields {
+			if err := field.schema.encode(b, values[field.name]); err != nil {
+				return fmt.Errorf("%s: %v", field.name, err)
+			}
+		}
+		return nil
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok && v != nil {
+			items = []interface{}{v}
+		}
+		if len(items) > 0 {
+			writeAvroLong(b, int64(len(items)))
+			for _, item := range items {
+				if err := s.items.encode(b, item); err != nil {
+					return err
+				}
+			}
+		}
+		writeAvroLong(b, 0)
+		return nil
+	}
+
+	text, ok := v.(string)
+	if object, isObject := v.(jsonObject); isObject {
+		for _, field := range object {
+			if field.key == "#text" {
+				text, ok = field.value.(string)
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s value missing", s.kind)
+	}
+	switch s.kind {
+	case "string", "bytes":
+		writeAvroLong(b, int64(len(text)))
+		b.WriteString(text)
+	case "boolean":
+		value, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return err
+		}
+		if value {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case "int", "long":
+		bits := 64
+		if s.kind == "int" {
+			bits = 32
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(text), 10, bits)
+		if err != nil {
+			return err
+		}
+		writeAvroLong(b, value)
+	case "float":
+		value, err := strconv.ParseFloat(strings.TrimSpace(text), 32)
+		if err != nil {
+			return err
+		}
+		binary.Write(b, binary.LittleEndian, math.Float32bits(float32(value)))
+	case "double":
+		value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return err
+		}
+		binary.Write(b, binary.LittleEndian, math.Float64bits(value))
+	}
+	return nil
+}
+
+// writeAvroLong writes a zig-zag encoded variable length integer.
+func writeAvroLong(b *bytes.Buffer, value int64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutVarint(buf[:], value)])
+}
+
+func writeAvroBytes(b *bytes.Buffer, value []byte) {
+	writeAvroLong(b, int64(len(value)))
+	b.Write(value)
+}
+
+// avroWriter writes the records of a source file into Avro object container files. Until the schema
+// is known, records are held back as the sample it is inferred from.
+type avroWriter struct {
+	format     *avroFormat
+	conf       Config
+	source     string
+	scopes     recordScopes
+	schema     *avroSchema
+	schemaJSON []byte
+	sample     []interface{}
+	keys       []string
+	root       string
+	file       *outputFile
+	index      int
+	sync       [16]byte
+	block      bytes.Buffer
+	count      int64
+	listed     outputList
+}
+
+func newAvroWriter(conf Config, source string, format *avroFormat) *avroWriter {
+	return &avroWriter{format: format, conf: conf, source: source, scopes: make(recordScopes), schema: format.schema, schemaJSON: format.schemaJSON}
+}
+
+func (w *avroWriter) write(actions []ioAction) ([]ioAction, error) {
+	for len(actions) > 0 && actions[0].ready {
+		action := actions[0]
+		var err error
+		if action.envelope {
+			err = w.scopes.observe(action)
+		} else if action.actionType == writeFile {
+			err = w.writeRecord(action)
+		}
+		if err != nil {
+			return nil, err
+		}
+		actions = actions[1:]
+	}
+	return actions, nil
+}
+
+func (w *avroWriter) writeRecord(action ioAction) error {
+	rec, err := newRecord(w.conf, w.source, action, w.scopes)
+	if err != nil {
+		return err
+	}
+	value := w.format.json.value(rec.root)
+	if w.schema != nil {
+		return w.append(rec.key, value)
+	}
+	if w.root == "" {
+		w.root = rec.root.qualifiedName()
+	}
+	w.sample = append(w.sample, value)
+	w.keys = append(w.keys, rec.key)
+	if len(w.sample) >= w.format.sample {
+		return w.inferSchema()
+	}
+	return nil
+}
+
+// inferSchema infers the schema from the sample held back and then writes the sampled records.
+func (w *avroWriter) inferSchema() error {
+	var schema *avroSchema
+	for _, value := range w.sample {
+		schema = mergeAvroSchemas(schema, inferAvroSchema(value, avroName(w.root)))
+	}
+	w.schema = schema
+	var err error
+	if w.schemaJSON, err = marshalJSON(schema.definition(), false); err != nil {
+		return err
+	}
+	for i, value := range w.sample {
+		if err := w.append(w.keys[i], value); err != nil {
+			return err
+		}
+	}
+	w.sample, w.keys = nil, nil
+	return nil
+}
+
+// append encodes a record into the current block, writing the block once it is full.
+func (w *avroWriter) append(key string, value interface{}) error {
+	var b bytes.Buffer
+	err := w.schema.encode(&b, value)
+	if err != nil && w.format.schema == nil {
+		if err := w.widen(value); err != nil {
+			return err
+		}
+		b.Reset()
+		err = w.schema.encode(&b, value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: record does not match the avro schema: %v", key, err)
+	}
+	w.block.Write(b.Bytes())
+	w.count++
+	if w.block.Len() >= avroBlockSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// widen merges the schema of a record that does not fit the inferred schema into it. As the schema of
+// a container file is fixed, the records written so far are flushed and their file closed, so the
+// next file is started with the wider schema.
+func (w *avroWriter) widen(value interface{}) error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if w.file != nil {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+		w.index++
+	}
+	w.schema = mergeAvroSchemas(w.schema, inferAvroSchema(value, avroName(w.root)))
+	var err error
+	w.schemaJSON, err = marshalJSON(w.schema.definition(), false)
+	return err
+}
+
+// flush writes the current block, starting a new file first if the current one has reached the
+// rollover size.
+func (w *avroWriter) flush() error {
+	if w.count == 0 {
+		return nil
+	}
+	if w.file != nil && w.conf.rollover > 0 && w.file.size() >= w.conf.rollover {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+		w.index++
+	}
+	if w.file == nil {
+		if err := w.create(); err != nil {
+			return err
+		}
+	}
+	data := w.block.Bytes()
+	if w.format.codec == "deflate" {
+		var compressed bytes.Buffer
+		compressor, err := flate.NewWriter(&compressed, w.format.level)
+		if err != nil {
+			return err
+		}
+		if _, err := compressor.Write(data); err != nil {
+			return err
+		}
+		if err := compressor.Close(); err != nil {
+			return err
+		}
+		data = compressed.Bytes()
+	}
+	var b bytes.Buffer
+	writeAvroLong(&b, w.count)
+	writeAvroBytes(&b, data)
+	b.Write(w.sync[:])
+	w.listed.count(int(w.count))
+	w.block.Reset()
+	w.count = 0
+	_, err := w.file.Write(b.Bytes())
+	if err == nil {
+		// The file is flushed so its size on disk can be checked against the rollover size.
+		err = w.file.buffered.Flush()
+	}
+	return err
+}
+
+// create starts a new container file and writes its header.
+func (w *avroWriter) create() error {
+	path := filepath.Join(w.conf.out, sourceName(w.source))
+	if w.conf.rollover > 0 || w.index > 0 {
+		path += fmt.Sprintf(".%d", w.index)
+	}
+	var err error
+	if w.file, err = createOutputFile(path+w.format.extension(), "", 0); err != nil {
+		return err
+	}
+	w.listed.open(w.file.file.Name(), w.file.counter)
+	if _, err := rand.Read(w.sync[:]); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	b.WriteString(avroMagic)
+	writeAvroLong(&b, 2)
+	writeAvroBytes(&b, []byte("avro.schema"))
+	writeAvroBytes(&b, w.schemaJSON)
+	writeAvroBytes(&b, []byte("avro.codec"))
+	writeAvroBytes(&b, []byte(w.format.codec))
+	writeAvroLong(&b, 0)
+	b.Write(w.sync[:])
+	_, err = w.file.Write(b.Bytes())
+	return err
+}
+
+func (w *avroWriter) close() error {
+	if w.schema == nil && len(w.sample) > 0 {
+		if err := w.inferSchema(); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if w.file == nil {
+		return nil
+	}
+	return w.closeFile()
+}
+
+// closeFile closes the current container file, finishing its entry in the manifest.
+func (w *avroWriter) closeFile() error {
+	err := w.file.Close()
+	w.listed.close()
+	w.file = nil
+	return err
+}
+
+func (w *avroWriter) outputs() []output {
+	return w.listed.take()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AvroSuite struct {
+	tempDirSuite
+}
+
+func TestAvroSuite(t *testing.T) {
+	suite.Run(t, new(AvroSuite))
+}
+
+func (s *AvroSuite) conf() Config {
+	return Config{out: s.dir, format: "avro", namespaces: "keep", avroSample: 1000, avroCodec: "deflate", compressLevel: flate.DefaultCompression}
+}
+
+func (s *AvroSuite) records(contents ...string) []ioAction {
+	actions := []ioAction{
+		{actionType: newDirectory, path: s.dir + "/sprot/uniprot/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/root.xml", lines: []string{`<uniprot xmlns="urn:u"/>`}, ready: true, envelope: true},
+	}
+	for i, content := range contents {
+		actions = append(actions, ioAction{actionType: writeFile, path: fmt.Sprintf("%s/sprot/uniprot/0/entry.%d.xml", s.dir, i), lines: []string{content}, ready: true})
+	}
+	return actions
+}
+
+func (s *AvroSuite) split(conf Config, contents ...string) {
+	w, err := newWriter(conf, "in/sprot.xml")
+	s.Require().NoError(err)
+	remaining, err := w.write(s.records(contents...))
+	s.Require().NoError(err)
+	s.Require().Empty(remaining)
+	s.Require().NoError(closeWriter(w))
+}
+
+func (s *AvroSuite) TestAvroName() {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "entry", want: "entry"},
+		{name: "@id", want: "_id"},
+		{name: "#text", want: "_text"},
+		{name: "dc:title", want: "dc_title"},
+		{name: "{urn:u}entry", want: "_urn_u_entry"},
+		{name: "1st", want: "_1st"},
+	}
+	for _, tt := range tests {
+		s.Assert().Equal(tt.want, avroName(tt.name))
+	}
+}
+
+func (s *AvroSuite) TestInferSchema() {
+	f := newJSONFormat(Config{namespaces: "keep"})
+	var schema *avroSchema
+	for _, content := range []string{
+		`<entry id="1"><name>a</name><name>b</name><organism><taxon>9606</taxon></organism></entry>`,
+		`<entry id="2"><name>c</name><organism>unknown</organism><comment/></entry>`,
+	} {
+		root, err := parseRecord(content, nil)
+		s.Require().NoError(err)
+		schema = mergeAvroSchemas(schema, inferAvroSchema(f.value(root), "entry"))
+	}
+	definition, err := marshalJSON(schema.definition(), false)
+	s.Require().NoError(err)
+	s.Assert().Equal(`{"type":"record","name":"entry","fields":[`+
+		`{"name":"_id","type":["null","string"],"default":null},`+
+		`{"name":"name","type":["null",{"type":"array","items":"string"}],"default":null},`+
+		`{"name":"organism","type":["null",{"type":"record","name":"entry_organism","fields":[{"name":"taxon","type":["null","string"],"default":null},{"name":"_text","type":["null","string"],"default":null}]}],"default":null},`+
+		`{"name":"comment","type":"null","default":null}]}`, string(definition))
+}
+
+func (s *AvroSuite) TestContainer() {
+	for _, codec := range []string{"null", "deflate"} {
+		conf := s.conf()
+		conf.avroCodec = codec
+		s.split(conf, `<entry id="1"><name>a</name></entry>`, `<entry id="2"><name>b</name><name>c</name></entry>`, `<entry id="3"/>`)
+
+		schema, records := s.read(filepath.Join(s.dir, "sprot.avro"), codec)
+		s.Assert().Equal(`{"type":"record","name":"entry","fields":[{"name":"_id","type":["null","string"],"default":null},{"name":"name","type":["null",{"type":"array","items":"string"}],"default":null}]}`, schema)
+		s.Assert().Equal([]interface{}{
+			map[string]interface{}{"_id": "1", "name": []interface{}{"a"}},
+			map[string]interface{}{"_id": "2", "name": []interface{}{"b", "c"}},
+			map[string]interface{}{"_id": "3", "name": nil},
+		}, records)
+	}
+}
+
+func (s *AvroSuite) TestRecordsAfterSample() {
+	conf := s.conf()
+	conf.avroSample = 1
+	s.split(conf, `<entry id="1"><name>a</name></entry>`, `<entry><name>b</name></entry>`, `<entry id="3"/>`)
+
+	_, records := s.read(filepath.Join(s.dir, "sprot.avro"), "deflate")
+	s.Assert().Equal([]interface{}{
+		map[string]interface{}{"_id": "1", "name": "a"},
+		map[string]interface{}{"_id": nil, "name": "b"},
+		map[string]interface{}{"_id": "3", "name": nil},
+	}, records)
+
+}
+
+func (s *AvroSuite) TestSchemaWidening() {
+	conf := s.conf()
+	conf.avroSample = 1
+	s.split(conf, `<entry id="1"><name>a</name></entry>`, `<entry id="2"><name>b</name><score>1</score></entry>`, `<entry id="3"/>`)
+
+	schema, records := s.read(filepath.Join(s.dir, "sprot.avro"), "deflate")
+	s.Assert().Equal(`{"type":"record","name":"entry","fields":[{"name":"_id","type":["null","string"],"default":null},{"name":"name","type":["null","string"],"default":null}]}`, schema)
+	s.Assert().Equal([]interface{}{map[string]interface{}{"_id": "1", "name": "a"}}, records)
+	schema, records = s.read(filepath.Join(s.dir, "sprot.1.avro"), "deflate")
+	s.Assert().Equal(`{"type":"record","name":"entry","fields":[{"name":"_id","type":["null","string"],"default":null},{"name":"name","type":["null","string"],"default":null},{"name":"score","type":["null","string"],"default":null}]}`, schema)
+	s.Assert().Equal([]interface{}{
+		map[string]interface{}{"_id": "2", "name": "b", "score": "1"},
+		map[string]interface{}{"_id": "3", "name": nil, "score": nil},
+	}, records)
+}
+
+func (s *AvroSuite) TestSuppliedSchema() {
+	path := filepath.Join(s.dir, "entry.avsc")
+	s.Require().NoError(ioutil.WriteFile(path, []byte(`{"type":"record","name":"Entry","fields":[
+		{"name":"_id","type":"long"},
+		{"name":"score","type":["double","null"]},
+		{"name":"reviewed","type":"boolean"},
+		{"name":"name","type":{"type":"array","items":"string"}}]}`), 0644))
+	conf := s.conf()
+	conf.avroSchema = path
+	s.split(conf, `<entry id="7"><score>0.5</score><reviewed>true</reviewed><name>a</name></entry>`, `<entry id="8"><reviewed>false</reviewed></entry>`)
+
+	_, records := s.read(filepath.Join(s.dir, "sprot.avro"), "deflate")
+	s.Assert().Equal([]interface{}{
+		map[string]interface{}{"_id": int64(7), "score": 0.5, "reviewed": true, "name": []interface{}{"a"}},
+		map[string]interface{}{"_id": int64(8), "score": nil, "reviewed": false, "name": []interface{}(nil)},
+	}, records)
+
+	w, err := newWriter(conf, "in/sprot.xml")
+	s.Require().NoError(err)
+	_, err = w.write(s.records(`<entry id="x"><reviewed>true</reviewed></entry>`))
+	s.Assert().Error(err)
+}
+
+func (s *AvroSuite) TestRollover() {
+	conf := s.conf()
+	conf.avroCodec = "null"
+	conf.rollover = 1
+	var contents []string
+	for i := 0; i < 20; i++ {
+		contents = append(contents, fmt.Sprintf("<entry><text>%s</text></entry>", bytes.Repeat([]byte{'a'}, avroBlockSize)))
+	}
+	s.split(conf, contents...)
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "sprot.*.avro"))
+	s.Require().NoError(err)
+	s.Assert().True(len(files) > 1)
+	var records []interface{}
+	for _, file := range files {
+		_, read := s.read(file, "null")
+		records = append(records, read...)
+	}
+	s.Assert().Len(records, 20)
+	for _, record := range records {
+		s.Assert().Equal(map[string]interface{}{"text": string(bytes.Repeat([]byte{'a'}, avroBlockSize))}, record)
+	}
+}
+
+// read decodes an object container file, returning its schema and records.
+func (s *AvroSuite) read(path, codec string) (string, []interface{}) {
+	content, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	r := bytes.NewReader(content)
+	magic := make([]byte, 4)
+	r.Read(magic)
+	s.Require().Equal(avroMagic, string(magic))
+
+	metadata := make(map[string]string)
+	for count := s.readLong(r); count != 0; count = s.readLong(r) {
+		for i := int64(0); i < count; i++ {
+			key := s.readBytes(r)
+			metadata[string(key)] = string(s.readBytes(r))
+		}
+	}
+	s.Require().Equal(codec, metadata["avro.codec"])
+	var definition interface{}
+	s.Require().NoError(json.Unmarshal([]byte(metadata["avro.schema"]), &definition))
+	schema, err := parseAvroSchema(definition, make(map[string]*avroSchema))
+	s.Require().NoError(err)
+	sync := make([]byte, 16)
+	r.Read(sync)
+
+	var records []interface{}
+	for r.Len() > 0 {
+		count := s.readLong(r)
+		data := s.readBytes(r)
+		if codec == "deflate" {
+			data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+			s.Require().NoError(err)
+		}
+		block := bytes.NewReader(data)
+		for i := int64(0); i < count; i++ {
+			records = append(records, s.decode(schema, block))
+		}
+		s.Require().Equal(0, block.Len())
+		marker := make([]byte, 16)
+		r.Read(marker)
+		s.Require().Equal(sync, marker)
+	}
+	return metadata["avro.schema"], records
+}
+
+func (s *AvroSuite) decode(schema *avroSchema, r *bytes.Reader) interface{} {
+	if schema.nullable && s.readLong(r) == int64(schema.nullIndex) {
+		return nil
+	}
+	switch schema.kind {
+	case "record":
+		record := make(map[string]interface{})
+		for _, field := range schema.fields {
+			record[field.name] = s.decode(field.schema, r)
+		}
+		return record
+	case "array":
+		var items []interface{}
+		for count := s.readLong(r); count != 0; count = s.readLong(r) {
+			for i := int64(0); i < count; i++ {
+				items = append(items, s.decode(schema.items, r))
+			}
+		}
+		return items
+	case "string":
+		return string(s.readBytes(r))
+	case "long":
+		return s.readLong(r)
+	case "boolean":
+		b, _ := r.ReadByte()
+		return b == 1
+	case "double":
+		var bits uint64
+		s.Require().NoError(binary.Read(r, binary.LittleEndian, &bits))
+		return math.Float64frombits(bits)
+	}
+	return nil
+}
+
+func (s *AvroSuite) readLong(r *bytes.Reader) int64 {
+	value, err := binary.ReadVarint(r)
+	s.Require().NoError(err)
+	return value
+}
+
+func (s *AvroSuite) readBytes(r *bytes.Reader) []byte {
+	value := make([]byte, s.readLong(r))
+	r.Read(value)
+	return value
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+)
+
+// bulkFormat writes records as Elasticsearch/OpenSearch bulk API requests: an index action line,
+// carrying the _index and optionally an _id taken from the record, followed by the record converted
+// to JSON. The document is the converted value of the record root, as produced by -format json,
+// rather than an object keyed by the root name. Bulk files are always streamed, so rollover at
+// -rollover keeps each file within the cluster's request size limit.
+type bulkFormat struct {
+	json  *jsonFormat
+	index string
+	id    string
+}
+
+func newBulkFormat(conf Config) *bulkFormat {
+	return &bulkFormat{json: newJSONFormat(conf), index: conf.bulkIndex, id: conf.bulkID}
+}
+
+func (f *bulkFormat) streamOnly() {}
+
+func (f *bulkFormat) extension() string {
+	return ".ndjson"
+}
+
+func (f *bulkFormat) streamExtension() string {
+	return ".ndjson"
+}
+
+func (f *bulkFormat) render(rec record) ([]byte, error) {
+	return f.renderLine(rec)
+}
+
+// renderLine returns both lines of the bulk request for the record. The _id is omitted, leaving the
+// cluster to generate one, when the configured path does not match anything in the record.
+func (f *bulkFormat) renderLine(rec record) ([]byte, error) {
+	index := f.index
+	if index == "" {
+		index = strings.ToLower(sourceName(rec.source))
+	}
+	metadata := jsonObject{{key: "_index", value: index}}
+	id := rec.key
+	if f.id != "" {
+		id = rec.root.value(f.id)
+	}
+	if id != "" {
+		metadata = append(metadata, jsonField{key: "_id", value: id})
+	}
+	action, err := marshalJSON(jsonObject{{key: "index", value: metadata}}, false)
+	if err != nil {
+		return nil, err
+	}
+	document := f.json.value(rec.root)
+	if _, ok := document.(jsonObject); !ok {
+		document = jsonObject{{key: "#text", value: document}}
+	}
+	content, err := marshalJSON(document, false)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(action, '\n'), content...), nil
+}
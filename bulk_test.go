@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BulkSuite struct {
+	tempDirSuite
+}
+
+func TestBulkSuite(t *testing.T) {
+	suite.Run(t, new(BulkSuite))
+}
+
+func (s *BulkSuite) TestRenderLine() {
+	tests := []struct {
+		conf    Config
+		content string
+		want    string
+	}{
+		{
+			conf:    Config{namespaces: "keep", bulkIndex: "articles", bulkID: "MedlineCitation/PMID"},
+			content: `<PubmedArticle><MedlineCitation><PMID>123</PMID></MedlineCitation></PubmedArticle>`,
+			want: `{"index":{"_index":"articles","_id":"123"}}` + "\n" +
+				`{"MedlineCitation":{"PMID":"123"}}`,
+		},
+		{
+			conf:    Config{namespaces: "keep"},
+			content: `<entry>text</entry>`,
+			want: `{"index":{"_index":"sprot","_id":"sprot/uniprot/0/entry.0"}}` + "\n" +
+				`{"#text":"text"}`,
+		},
+		{
+			conf:    Config{namespaces: "keep", bulkID: "@id"},
+			content: `<entry><name>n</name></entry>`,
+			want: `{"index":{"_index":"sprot"}}` + "\n" +
+				`{"name":"n"}`,
+		},
+	}
+	for _, tt := range tests {
+		root, err := parseRecord(tt.content, nil)
+		s.Require().NoError(err)
+		line, err := newBulkFormat(tt.conf).renderLine(record{key: "sprot/uniprot/0/entry.0", source: "in/Sprot.xml", root: root})
+		s.Require().NoError(err)
+		s.Assert().Equal(tt.want, string(line))
+	}
+}
+
+func (s *BulkSuite) TestRollover() {
+	w, err := newWriter(Config{out: s.dir, format: "bulk", namespaces: "keep", rollover: 100}, "in/sprot.xml")
+	s.Require().NoError(err)
+	var actions []ioAction
+	for i := 0; i < 4; i++ {
+		actions = append(actions, ioAction{actionType: writeFile, path: fmt.Sprintf("%s/sprot/uniprot/0/entry.%d.xml", s.dir, i), lines: []string{"<entry>", "a", "</entry>"}, ready: true})
+	}
+	_, err = w.write(actions)
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "sprot.*.ndjson"))
+	s.Require().NoError(err)
+	s.Assert().Len(files, 2)
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "sprot.1.ndjson"))
+	s.Require().NoError(err)
+	s.Assert().Equal(`{"index":{"_index":"sprot","_id":"sprot/uniprot/0/entry.2"}}`+"\n"+`{"#text":"a"}`+"\n"+
+		`{"index":{"_index":"sprot","_id":"sprot/uniprot/0/entry.3"}}`+"\n"+`{"#text":"a"}`+"\n", string(content))
+}
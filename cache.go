@@ -3,10 +3,14 @@ package main
 import (
 	"encoding/xml"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+// reservedFileName matches the names of the files the splitter generates: envelopes and numbered records.
+var reservedFileName = regexp.MustCompile(`^root$|\.[0-9]+$`)
+
 type processCache struct {
 	depth            int
 	currentDirectory []string
@@ -17,6 +21,33 @@ type processCache struct {
 	line             string
 	file             bool
 	ioActions        []ioAction
+	// ancestors are the opening tags of the elements enclosing the current position above the split depth.
+	ancestors []Tag
+	// parents are the keys of the elements enclosing the current position above the split depth.
+	parents []string
+	// record is the opening tag of the record currently being written.
+	record Tag
+	// ontology holds the lines of the owl:Ontology header once it has been read in OWL mode.
+	ontology []string
+	// entities are the general entities declared in the DOCTYPE of the source, keyed by name.
+	entities map[string]string
+	// envelopes are the full envelopes of the elements enclosing the current position above the
+	// split depth, written as each element ends.
+	envelopes []ioAction
+	// records is the number of records closed so far.
+	records int
+	// lineOffset and lineNumber locate the last line read in the source, and segments map the
+	// line being processed back to the lines it was read from.
+	lineOffset int64
+	lineNumber int
+	segments   []lineSegment
+	// stripped is the text removed from the line being processed by -strip.
+	stripped []strippedSpan
+	// recordLine is the index of the record root's start tag within the lines of its file, and
+	// recordOffset and recordLineNumber locate it in the source.
+	recordLine       int
+	recordOffset     int64
+	recordLineNumber int
 }
 
 func (p *processCache) newDirectory(name string) {
@@ -48,6 +79,25 @@ func (p *processCache) openFile(prefix string) {
 	p.totalFiles++
 }
 
+// openNamedFile starts a file named after a record rather than its element. Further files given
+// the same name in the current directory are numbered <name>~<n>, as ~ never appears in a name, and
+// so are names that could be taken for an envelope or for an <element>.<n> file.
+func (p *processCache) openNamedFile(name string) {
+	filekey := strings.Join(p.currentDirectory, "/") + "/" + name
+	n, ok := p.fileCounter[filekey+"~"]
+	if ok {
+		n++
+	}
+	p.fileCounter[filekey+"~"] = n
+	path := filekey + ".xml"
+	if n > 0 || reservedFileName.MatchString(name) {
+		path = fmt.Sprintf("%s~%d.xml", filekey, n)
+	}
+	p.ioActions = append(p.ioActions, ioAction{actionType: writeFile, path: path, lines: []string{xml.Header}})
+	p.file = true
+	p.totalFiles++
+}
+
 func (p *processCache) closeFile() {
 	p.ioActions[len(p.ioActions)-1].ready = true
 	p.file = false
@@ -58,6 +108,41 @@ func (p *processCache) appendLine(line string) {
 }
 
 func (p *processCache) appendFile(name, text string) {
-	p.ioActions = append(p.ioActions, ioAction{actionType: writeFile, path: strings.Join(append(p.currentDirectory, name), "/") + ".xml", ready: true, lines: []string{xml.Header + text}})
+	p.ioActions = append(p.ioActions, ioAction{actionType: writeFile, path: strings.Join(append(p.currentDirectory, name), "/") + ".xml", ready: true, lines: []string{xml.Header + text}, envelope: true})
 	p.totalFiles++
 }
+
+// enterEnvelope starts the full envelope of the element opened by tag in the current directory. The
+// start tag is passed on straight away, so writers know the scope records inherit before the
+// envelope itself is written.
+func (p *processCache) enterEnvelope(tag string) {
+	path := strings.Join(p.currentDirectory, "/") + "/root.xml"
+	p.ioActions = append(p.ioActions, ioAction{actionType: openEnvelope, path: path, lines: []string{xml.Header + emptyElement(tag)}, ready: true, envelope: true})
+	p.envelopes = append(p.envelopes, ioAction{actionType: writeFile, path: path, lines: []string{xml.Header, tag}, ready: true, envelope: true})
+}
+
+// appendEnvelope adds a line to the envelope of the innermost element above the split depth.
+func (p *processCache) appendEnvelope(line string) {
+	if len(p.envelopes) > 0 {
+		p.envelopes[len(p.envelopes)-1].lines = append(p.envelopes[len(p.envelopes)-1].lines, line)
+	}
+}
+
+// exitEnvelope completes the innermost envelope with the given end tag, if any, and writes it.
+func (p *processCache) exitEnvelope(tag string) {
+	envelope := p.envelopes[len(p.envelopes)-1]
+	if tag != "" {
+		envelope.lines = append(envelope.lines, tag)
+	}
+	p.envelopes = p.envelopes[:len(p.envelopes)-1]
+	p.ioActions = append(p.ioActions, envelope)
+	p.totalFiles++
+}
+
+// emptyElement turns a start tag into an empty element tag.
+func emptyElement(tag string) string {
+	if strings.HasSuffix(tag, "/>") {
+		return tag
+	}
+	return tag[:len(tag)-1] + "/>"
+}
@@ -154,6 +154,34 @@ func (s *CacheSuite) TestNewFile() {
 	}
 }
 
+func (s *CacheSuite) TestNewNamedFile() {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "GO_0000001", want: "out/go/rdf:RDF/0/GO_0000001.xml"},
+		{name: "GO_0000001", want: "out/go/rdf:RDF/0/GO_0000001~1.xml"},
+		{name: "part_of", want: "out/go/rdf:RDF/0/part_of.xml"},
+		{name: "GO_0000001.1", want: "out/go/rdf:RDF/0/GO_0000001.1~0.xml"},
+		{name: "root", want: "out/go/rdf:RDF/0/root~0.xml"},
+		{name: "root", want: "out/go/rdf:RDF/0/root~1.xml"},
+	}
+	cache := &processCache{
+		currentDirectory: []string{"out", "go", "rdf:RDF", "0"},
+		fileCounter:      make(map[string]int),
+	}
+	for _, tt := range tests {
+		cache.openNamedFile(tt.name)
+		s.Assert().Equal(tt.want, cache.ioActions[len(cache.ioActions)-1].path)
+		s.Assert().True(cache.file)
+		cache.closeFile()
+	}
+	s.Assert().Equal(6, cache.totalFiles)
+
+	cache.openFile("GO_0000001")
+	s.Assert().Equal("out/go/rdf:RDF/0/GO_0000001.0.xml", cache.ioActions[len(cache.ioActions)-1].path)
+}
+
 func (s *CacheSuite) TestExitDirectory() {
 	tests := []struct {
 		cache *processCache
@@ -258,7 +286,7 @@ func (s *CacheSuite) TestAppendFile() {
 				currentDirectory: []string{"output", "target", "xml-tag", "0"},
 				ioActions: []ioAction{
 					{actionType: writeFile, lines: []string{"xml file"}, ready: true},
-					{actionType: writeFile, lines: []string{xml.Header + "text for new file"}, ready: true, path: "output/target/xml-tag/0/filename.xml"},
+					{actionType: writeFile, lines: []string{xml.Header + "text for new file"}, ready: true, path: "output/target/xml-tag/0/filename.xml", envelope: true},
 				},
 			},
 		},
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// compressionExtensions maps each supported -compress value to the extension appended to output files.
+var compressionExtensions = map[string]string{
+	"gzip":  ".gz",
+	"zlib":  ".zz",
+	"flate": ".deflate",
+}
+
+// compressWriter writes each output file compressed. Directories are created as actions arrive,
+// while files are handed to a background goroutine so compression runs alongside parsing.
+type compressWriter struct {
+	format string
+	level  int
+	jobs   chan compressJob
+	done   chan struct{}
+	mu     sync.Mutex
+	err    error
+	list   bool
+	listed outputList
+}
+
+func newCompressWriter(conf Config) *compressWriter {
+	w := &compressWriter{
+		format: conf.compress,
+		level:  conf.compressLevel,
+		jobs:   make(chan compressJob, conf.buffer),
+		done:   make(chan struct{}),
+		list:   conf.manifest != "",
+	}
+	go w.run()
+	return w
+}
+
+func (w *compressWriter) write(actions []ioAction) ([]ioAction, error) {
+	for len(actions) > 0 && actions[0].ready {
+		if err := w.failed(); err != nil {
+			return nil, err
+		}
+		action := actions[0]
+		switch action.actionType {
+		case writeFile:
+			action.path += compressionExtensions[w.format]
+			w.jobs <- compressJob{action: action, stored: newCountingWriter(nil)}
+		case newDirectory:
+			if err := os.MkdirAll(action.path, 0755); err != nil {
+				return nil, err
+			}
+		}
+		actions = actions[1:]
+	}
+	return actions, nil
+}
+
+// close waits for queued files to be written and reports the first error encountered.
+func (w *compressWriter) close() error {
+	close(w.jobs)
+	<-w.done
+	return w.failed()
+}
+
+// outputs returns the files written in the background since it was last called.
+func (w *compressWriter) outputs() []output {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.listed.take()
+}
+
+func (w *compressWriter) run() {
+	defer close(w.done)
+	for job := range w.jobs {
+		if w.failed() != nil {
+			continue
+		}
+		err := w.writeFile(job)
+		w.mu.Lock()
+		if err != nil {
+			w.err = err
+		} else if w.list {
+			w.listed.add(job.action.path, job.action, job.stored)
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *compressWriter) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// compressJob is a file queued to be written compressed, and the count of its bytes as stored.
+type compressJob struct {
+	action ioAction
+	stored *countingWriter
+}
+
+func (w *compressWriter) writeFile(job compressJob) error {
+	action := job.action
+	file, err := os.Create(action.path)
+	if err != nil {
+		return err
+	}
+	job.stored.w = file
+	buffered := bufio.NewWriter(job.stored)
+	compressor, err := newCompressor(w.format, buffered, w.level)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	for _, line := range action.lines {
+		if _, err := io.WriteString(compressor, line); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// trimCompression removes the extension of any compression format from name.
+func trimCompression(name string) string {
+	for _, ext := range compressionExtensions {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// newCompressor wraps dst in the named compression format.
+func newCompressor(format string, dst io.Writer, level int) (io.WriteCloser, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewWriterLevel(dst, level)
+	case "zlib":
+		return zlib.NewWriterLevel(dst, level)
+	case "flate":
+		return flate.NewWriter(dst, level)
+	}
+	return nil, fmt.Errorf("unknown compression format '%s'", format)
+}
+
+// newDecompressor reads src in the named compression format.
+func newDecompressor(format string, src io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewReader(src)
+	case "zlib":
+		return zlib.NewReader(src)
+	case "flate":
+		return flate.NewReader(src), nil
+	}
+	return nil, fmt.Errorf("unknown compression format '%s'", format)
+}
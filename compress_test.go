@@ -0,0 +1,63 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CompressSuite struct {
+	tempDirSuite
+}
+
+func TestCompressSuite(t *testing.T) {
+	suite.Run(t, new(CompressSuite))
+}
+
+func (s *CompressSuite) TestWrite() {
+	tests := []struct {
+		format string
+		reader func(io.Reader) (io.Reader, error)
+	}{
+		{format: "gzip", reader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{format: "zlib", reader: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{format: "flate", reader: func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil }},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(s.dir, tt.format, "uniprot", "0")
+		w := newCompressWriter(Config{compress: tt.format, compressLevel: flate.BestCompression, buffer: 1})
+		remaining, err := w.write([]ioAction{
+			{actionType: newDirectory, path: dir, ready: true},
+			{actionType: writeFile, path: dir + "/entry.0.xml", lines: []string{"<entry>", "a", "</entry>"}, ready: true},
+			{actionType: writeFile, path: dir + "/entry.1.xml", lines: []string{"<entry>"}},
+		})
+		s.Require().NoError(err)
+		s.Assert().Len(remaining, 1)
+		s.Require().NoError(w.close())
+
+		file, err := os.Open(dir + "/entry.0.xml" + compressionExtensions[tt.format])
+		s.Require().NoError(err)
+		r, err := tt.reader(file)
+		s.Require().NoError(err)
+		content, err := ioutil.ReadAll(r)
+		s.Require().NoError(err)
+		file.Close()
+		s.Assert().Equal("<entry>a</entry>", string(content))
+	}
+}
+
+func (s *CompressSuite) TestWriteError() {
+	w := newCompressWriter(Config{compress: "gzip", buffer: 1})
+	_, err := w.write([]ioAction{
+		{actionType: writeFile, path: filepath.Join(s.dir, "missing", "entry.0.xml"), lines: []string{"<entry/>"}, ready: true},
+	})
+	s.Require().NoError(err)
+	s.Assert().Error(w.close())
+}
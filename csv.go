@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strings"
+)
+
+// csvColumn is a named record-relative path extracted into a column.
+type csvColumn struct {
+	name string
+	path string
+}
+
+// csvFormat extracts columns from each record into a row of a CSV or TSV stream file, which starts
+// with a header row of the column names. When a path matches several values they are either joined
+// with a separator or only the first is kept.
+type csvFormat struct {
+	columns   []csvColumn
+	comma     rune
+	ext       string
+	first     bool
+	separator string
+}
+
+// newCSVFormat parses column specifications of the form name=path, or just path in which case the
+// path is also used as the name.
+func newCSVFormat(conf Config) (*csvFormat, error) {
+	if len(conf.columns) == 0 {
+		return nil, errors.New("-columns must be provided for csv and tsv formats")
+	}
+	switch conf.multiple {
+	case "join", "first":
+	default:
+		return nil, errors.New("-multiple must be join or first")
+	}
+	f := &csvFormat{comma: ',', ext: ".csv", first: conf.multiple == "first", separator: conf.multipleSeparator}
+	if conf.format == "tsv" {
+		f.comma, f.ext = '\t', ".tsv"
+	}
+	for _, spec := range conf.columns {
+		column := csvColumn{name: spec, path: spec}
+		if i := strings.Index(spec, "="); i >= 0 {
+			column = csvColumn{name: spec[:i], path: spec[i+1:]}
+		}
+		f.columns = append(f.columns, column)
+	}
+	return f, nil
+}
+
+func (f *csvFormat) streamOnly() {}
+
+func (f *csvFormat) extension() string {
+	return f.ext
+}
+
+func (f *csvFormat) streamExtension() string {
+	return f.ext
+}
+
+func (f *csvFormat) render(rec record) ([]byte, error) {
+	return f.renderLine(rec)
+}
+
+// header is the row of column names written at the start of each stream file.
+func (f *csvFormat) header() ([]byte, error) {
+	names := make([]string, len(f.columns))
+	for i, column := range f.columns {
+		names[i] = column.name
+	}
+	return f.row(names)
+}
+
+func (f *csvFormat) renderLine(rec record) ([]byte, error) {
+	values := make([]string, len(f.columns))
+	for i, column := range f.columns {
+		matches := rec.root.values(column.path)
+		if f.first && len(matches) > 0 {
+			matches = matches[:1]
+		}
+		values[i] = strings.Join(matches, f.separator)
+	}
+	return f.row(values)
+}
+
+// row encodes a single row, quoting values where required, without the trailing newline.
+func (f *csvFormat) row(values []string) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = f.comma
+	if err := w.Write(values); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CSVSuite struct {
+	suite.Suite
+}
+
+func TestCSVSuite(t *testing.T) {
+	suite.Run(t, new(CSVSuite))
+}
+
+const csvRecord = `<PubmedArticle><MedlineCitation><PMID>123</PMID><Article><ArticleTitle>Quotes "and", commas</ArticleTitle><AuthorList><Author><LastName>Smith</LastName></Author><Author><LastName>Jones</LastName></Author></AuthorList></Article></MedlineCitation></PubmedArticle>`
+
+func (s *CSVSuite) TestRenderLine() {
+	tests := []struct {
+		conf       Config
+		wantHeader string
+		want       string
+	}{
+		{
+			conf: Config{
+				format:            "csv",
+				columns:           []string{"pmid=MedlineCitation/PMID", "title=MedlineCitation/Article/ArticleTitle", "MedlineCitation/Article/AuthorList/Author/LastName", "missing=Missing"},
+				multiple:          "join",
+				multipleSeparator: "|",
+			},
+			wantHeader: "pmid,title,MedlineCitation/Article/AuthorList/Author/LastName,missing",
+			want:       `123,"Quotes ""and"", commas",Smith|Jones,`,
+		},
+		{
+			conf: Config{
+				format:   "tsv",
+				columns:  []string{"pmid=MedlineCitation/PMID", "author=MedlineCitation/Article/AuthorList/Author/LastName"},
+				multiple: "first",
+			},
+			wantHeader: "pmid\tauthor",
+			want:       "123\tSmith",
+		},
+	}
+	root, err := parseRecord(csvRecord, nil)
+	s.Require().NoError(err)
+	for _, tt := range tests {
+		f, err := newCSVFormat(tt.conf)
+		s.Require().NoError(err)
+		header, err := f.header()
+		s.Require().NoError(err)
+		s.Assert().Equal(tt.wantHeader, string(header))
+		line, err := f.renderLine(record{root: root})
+		s.Require().NoError(err)
+		s.Assert().Equal(tt.want, string(line))
+	}
+}
+
+func (s *CSVSuite) TestInvalidConfig() {
+	_, err := newCSVFormat(Config{format: "csv", multiple: "join"})
+	s.Assert().Error(err)
+	_, err = newCSVFormat(Config{format: "csv", columns: []string{"a"}, multiple: "last"})
+	s.Assert().Error(err)
+}
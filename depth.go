@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// depthChoice is the split depth detected for a file with -depth auto.
+type depthChoice struct {
+	depth int
+	// element is the name of the records at depth, and share the proportion of the sampled content
+	// at depth they hold.
+	element string
+	share   float64
+}
+
+// levelElement totals the elements of a name at a level of a document.
+type levelElement struct {
+	name     string
+	bytes    int64
+	repeated bool
+}
+
+// detectDepth samples the first sample bytes of the document read from r and returns the shallowest
+// level at which elements of one name appear more than once under the same parent and hold at least
+// confidence of the content at that level. Elements still open at the end of the sample count with
+// the content read so far. It returns false if no level qualifies.
+func detectDepth(r io.Reader, sample int64, confidence float64) (depthChoice, bool, error) {
+	limited := &io.LimitedReader{R: r, N: sample}
+	if sample > 0 {
+		r = limited
+	}
+	type frame struct {
+		name     string
+		start    int64
+		children map[string]int
+	}
+	var levels []map[string]*levelElement
+	var orders [][]string
+	var stack []*frame
+	add := func(level int, name string, bytes int64) {
+		levels[level][name].bytes += bytes
+	}
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	for {
+		start := d.InputOffset()
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if sample > 0 && limited.N == 0 {
+				break
+			}
+			return depthChoice{}, false, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			name := qualifiedName(t.Name)
+			level := len(stack)
+			if level > 0 {
+				for len(levels) <= level {
+					levels = append(levels, make(map[string]*levelElement))
+					orders = append(orders, nil)
+				}
+				e, ok := levels[level][name]
+				if !ok {
+					e = &levelElement{name: name}
+					levels[level][name] = e
+					orders[level] = append(orders[level], name)
+				}
+				parent := stack[level-1]
+				parent.children[name]++
+				if parent.children[name] > 1 {
+					e.repeated = true
+				}
+			}
+			stack = append(stack, &frame{name: name, start: start, children: make(map[string]int)})
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return depthChoice{}, false, &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">"}
+			}
+			level := len(stack) - 1
+			if level > 0 {
+				add(level, stack[level].name, d.InputOffset()-stack[level].start)
+			}
+			stack = stack[:level]
+		}
+	}
+	for level := 1; level < len(stack); level++ {
+		add(level, stack[level].name, d.InputOffset()-stack[level].start)
+	}
+
+	for level := 1; level < len(levels); level++ {
+		var total int64
+		var dominant *levelElement
+		for _, name := range orders[level] {
+			e := levels[level][name]
+			total += e.bytes
+			if e.repeated && (dominant == nil || e.bytes > dominant.bytes) {
+				dominant = e
+			}
+		}
+		if dominant == nil || total == 0 {
+			continue
+		}
+		share := float64(dominant.bytes) / float64(total)
+		if share >= confidence {
+			return depthChoice{depth: level, element: dominant.name, share: share}, true, nil
+		}
+	}
+	return depthChoice{}, false, nil
+}
+
+// resolveDepth returns conf with the depth detected for the file at path when -depth auto is given,
+// falling back to -depth-fallback when none is found with enough confidence.
+func resolveDepth(conf Config, path string) (Config, error) {
+	if !conf.autoDepth {
+		return conf, nil
+	}
+	r, err := openSource(path)
+	if err != nil {
+		return conf, err
+	}
+	defer r.Close()
+	choice, ok, err := detectDepth(r, conf.depthSample, conf.depthConfidence)
+	if err != nil {
+		return conf, fmt.Errorf("%s: %v", path, err)
+	}
+	if !ok {
+		conf.depth = conf.depthFallback
+		fmt.Printf("no split depth found for %s with confidence %.2f, falling back to depth %d\n", path, conf.depthConfidence, conf.depth)
+		return conf, nil
+	}
+	conf.depth = choice.depth
+	fmt.Printf("splitting %s at depth %d, where %s holds %.0f%% of the sampled content\n", path, choice.depth, choice.element, choice.share*100)
+	return conf, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type DepthSuite struct {
+	tempDirSuite
+}
+
+func TestDepthSuite(t *testing.T) {
+	suite.Run(t, new(DepthSuite))
+}
+
+func (s *DepthSuite) TestDetectDepth() {
+	tests := []struct {
+		name       string
+		xml        string
+		sample     int64
+		confidence float64
+		want       depthChoice
+		found      bool
+	}{
+		{
+			name:       "repeated children of the root",
+			xml:        `<?xml version="1.0"?><set><item>a</item><item>b</item></set>`,
+			confidence: 0.8,
+			want:       depthChoice{depth: 1, element: "item", share: 1},
+			found:      true,
+		},
+		{
+			name:       "records inside a wrapper",
+			xml:        `<set><header/><records><record><id>1</id></record><record><id>2</id></record></records></set>`,
+			confidence: 0.8,
+			want:       depthChoice{depth: 2, element: "record", share: 1},
+			found:      true,
+		},
+		{
+			name:       "repeated element that does not dominate",
+			xml:        `<set><a/><a/><header>a long header that holds most of the content</header></set>`,
+			confidence: 0.8,
+		},
+		{
+			name:       "repeated element that dominates at a lower confidence",
+			xml:        `<set><a>12345678</a><a>12345678</a><b>123</b></set>`,
+			confidence: 0.7,
+			want:       depthChoice{depth: 1, element: "a", share: 0.75},
+			found:      true,
+		},
+		{
+			name:       "sample ending inside a record",
+			xml:        `<set><entry>1</entry><entry><name>a very long record that is cut short by the sample`,
+			sample:     60,
+			confidence: 0.8,
+			want:       depthChoice{depth: 1, element: "entry", share: 1},
+			found:      true,
+		},
+		{
+			name:       "no repeated elements",
+			xml:        `<set><a/><b/></set>`,
+			confidence: 0.8,
+		},
+	}
+	for _, test := range tests {
+		s.Run(test.name, func() {
+			choice, found, err := detectDepth(strings.NewReader(test.xml), test.sample, test.confidence)
+			s.Require().NoError(err)
+			s.Equal(test.found, found)
+			if found {
+				s.Equal(test.want, choice)
+			}
+		})
+	}
+	_, _, err := detectDepth(strings.NewReader(`<set></set></a>`), 0, 0.8)
+	s.Error(err)
+}
+
+func (s *DepthSuite) TestResolveDepth() {
+	path := filepath.Join(s.dir, "set.xml.gz")
+	file, err := os.Create(path)
+	s.Require().NoError(err)
+	gz := gzip.NewWriter(file)
+	_, err = gz.Write([]byte(`<set><records><r/><r/></records></set>`))
+	s.Require().NoError(err)
+	s.Require().NoError(gz.Close())
+	s.Require().NoError(file.Close())
+
+	conf, err := resolveDepth(Config{depth: 1}, path)
+	s.Require().NoError(err)
+	s.Equal(1, conf.depth)
+	conf, err = resolveDepth(Config{depth: 3, autoDepth: true, depthFallback: 3, depthConfidence: 0.8}, path)
+	s.Require().NoError(err)
+	s.Equal(2, conf.depth)
+	conf, err = resolveDepth(Config{depth: 3, autoDepth: true, depthFallback: 3, depthConfidence: 0.8}, filepath.Join(s.dir, "set.xml"))
+	s.Error(err)
+}
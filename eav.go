@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// eavFormat writes every leaf value of every record as a row of a long format TSV stream file with
+// the columns record, source, path, name and value. The record is the record's key, the path locates
+// the element from the record root with 1-based positional indices among same-named siblings, e.g.
+// /PubmedArticle[1]/MedlineCitation[1]/PMID[1], and the name is either an attribute name or #text
+// for the element's own text.
+//
+// Elements can be filtered by matching their record-relative path, without indices, against the
+// -eav-include and -eav-exclude regular expressions.
+type eavFormat struct {
+	csv     *csvFormat
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func newEAVFormat(conf Config) *eavFormat {
+	return &eavFormat{
+		csv:     &csvFormat{comma: '\t'},
+		include: conf.eavInclude,
+		exclude: conf.eavExclude,
+	}
+}
+
+func (f *eavFormat) streamOnly() {}
+
+func (f *eavFormat) extension() string {
+	return ".eav.tsv"
+}
+
+func (f *eavFormat) streamExtension() string {
+	return ".eav.tsv"
+}
+
+func (f *eavFormat) render(rec record) ([]byte, error) {
+	return f.renderLine(rec)
+}
+
+func (f *eavFormat) header() ([]byte, error) {
+	return f.csv.row([]string{"record", "source", "path", "name", "value"})
+}
+
+// renderLine returns a row for each leaf value of the record, or nil when there are none.
+func (f *eavFormat) renderLine(rec record) ([]byte, error) {
+	var rows [][]byte
+	err := f.walk(rec.root, "", func(path, name, value string) error {
+		row, err := f.csv.row([]string{rec.key, rec.source, path, name, value})
+		rows = append(rows, row)
+		return err
+	})
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return bytes.Join(rows, []byte("\n")), nil
+}
+
+// walk calls emit for each attribute and text value of n and its descendants that pass the filters.
+func (f *eavFormat) walk(n *node, parent string, emit func(path, name, value string) error) error {
+	path := fmt.Sprintf("%s/%s[%d]", parent, n.qualifiedName(), n.position())
+	relative := n.path()
+	if (f.include == nil || f.include.MatchString(relative)) && (f.exclude == nil || !f.exclude.MatchString(relative)) {
+		for _, attr := range n.attrs {
+			if err := emit(path, qualifiedName(attr.Name), attr.Value); err != nil {
+				return err
+			}
+		}
+		if text := n.ownText(); strings.TrimSpace(text) != "" {
+			if err := emit(path, "#text", text); err != nil {
+				return err
+			}
+		}
+	}
+	for _, child := range n.elements() {
+		if err := f.walk(child, path, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EAVSuite struct {
+	suite.Suite
+}
+
+func TestEAVSuite(t *testing.T) {
+	suite.Run(t, new(EAVSuite))
+}
+
+func (s *EAVSuite) TestRenderLine() {
+	content := `<entry id="1"><name>a</name><name lang="en">b	c</name><empty/></entry>`
+	tests := []struct {
+		conf Config
+		want string
+	}{
+		{
+			conf: Config{},
+			want: "k\tin/s.xml\t/entry[1]\tid\t1\n" +
+				"k\tin/s.xml\t/entry[1]/name[1]\t#text\ta\n" +
+				"k\tin/s.xml\t/entry[1]/name[2]\tlang\ten\n" +
+				"k\tin/s.xml\t/entry[1]/name[2]\t#text\t\"b\tc\"",
+		},
+		{
+			conf: Config{eavInclude: regexp.MustCompile("^name$"), eavExclude: regexp.MustCompile("x")},
+			want: "k\tin/s.xml\t/entry[1]/name[1]\t#text\ta\n" +
+				"k\tin/s.xml\t/entry[1]/name[2]\tlang\ten\n" +
+				"k\tin/s.xml\t/entry[1]/name[2]\t#text\t\"b\tc\"",
+		},
+		{
+			conf: Config{eavExclude: regexp.MustCompile(".*")},
+			want: "",
+		},
+	}
+	root, err := parseRecord(content, nil)
+	s.Require().NoError(err)
+	for _, tt := range tests {
+		line, err := newEAVFormat(tt.conf).renderLine(record{key: "k", source: "in/s.xml", root: root})
+		s.Require().NoError(err)
+		s.Assert().Equal(tt.want, string(line))
+	}
+}
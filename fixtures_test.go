@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// tempDirSuite is embedded by the suites whose tests write files, giving each test an empty
+// temporary directory, dir, that is removed once it has run.
+type tempDirSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (s *tempDirSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "xml-splitter")
+	s.Require().NoError(err)
+	s.dir = dir
+}
+
+func (s *tempDirSuite) TearDownTest() {
+	s.Require().NoError(os.RemoveAll(s.dir))
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// recordFormat renders split records in an output format other than XML.
+type recordFormat interface {
+	// extension replaces .xml on records written as individual files.
+	extension() string
+	// streamExtension is appended to the source name for the stream file written with -stream.
+	streamExtension() string
+	// render converts a record for writing as an individual file.
+	render(rec record) ([]byte, error)
+	// renderLine converts a record into a single line of a stream file, without the trailing newline.
+	// Formats may return nil to write nothing for the record.
+	renderLine(rec record) ([]byte, error)
+}
+
+// streamFormat is implemented by formats that can only be written as stream files.
+type streamFormat interface {
+	recordFormat
+	streamOnly()
+}
+
+// sidecarFormat is implemented by formats that write a second file alongside each record.
+type sidecarFormat interface {
+	// renderWithSidecar converts a record as render does, and also returns the extension, replacing
+	// .xml, and the content of the file written alongside it, so both come from a single pass.
+	renderWithSidecar(rec record) (content []byte, ext string, sidecar []byte, err error)
+}
+
+// headerFormat is implemented by stream formats that begin each stream file with a header line.
+type headerFormat interface {
+	header() ([]byte, error)
+}
+
+// isStreamed reports whether records are written as stream files rather than a file each.
+func isStreamed(conf Config, format recordFormat) bool {
+	if _, ok := format.(streamFormat); ok {
+		return true
+	}
+	return format != nil && conf.stream
+}
+
+// record is a split record parsed for rendering in another format.
+type record struct {
+	// key is the path of the record relative to the output folder, without the extension.
+	key    string
+	source string
+	root   *node
+}
+
+// newFormat returns the recordFormat selected by -format, or nil when records are written as XML.
+func newFormat(conf Config) (recordFormat, error) {
+	switch conf.format {
+	case "", "xml":
+		return nil, nil
+	case "json":
+		return newJSONFormat(conf), nil
+	case "bulk":
+		return newBulkFormat(conf), nil
+	case "csv", "tsv":
+		return newCSVFormat(conf)
+	case "eav":
+		return newEAVFormat(conf), nil
+	case "text":
+		return newTextFormat(conf), nil
+	case "standoff":
+		return newStandoffFormat(conf), nil
+	case "ntriples":
+		return &ntriplesFormat{}, nil
+	case "avro":
+		return newAvroFormat(conf)
+	case "template":
+		return newTemplateFormat(conf)
+	}
+	return nil, fmt.Errorf("unknown format '%s'", conf.format)
+}
+
+// newRecord parses the content of a writeFile action into a record, resolving what it inherits
+// from its ancestors from scopes.
+func newRecord(conf Config, source string, action ioAction, scopes recordScopes) (record, error) {
+	root, err := parseRecord(strings.Join(action.lines, ""), scopes[filepath.Dir(action.path)])
+	if err != nil {
+		return record{}, fmt.Errorf("%s: %v", action.path, err)
+	}
+	key := relativePath(conf.out, action.path)
+	return record{key: strings.TrimSuffix(key, filepath.Ext(key)), source: source, root: root}, nil
+}
+
+// recordScopes holds the scope established by the envelopes of each output directory and their
+// ancestors, keyed by directory, so records can be parsed with the namespace declarations and
+// xml:lang and xml:base attributes made above them.
+type recordScopes map[string]*scope
+
+// observe records the scope established by an envelope. The envelope of the document element
+// carries the entities declared in the DOCTYPE of the source.
+func (s recordScopes) observe(action ioAction) error {
+	dir := filepath.Dir(action.path)
+	current := &scope{namespaces: make(map[string]string), entities: action.entities}
+	// Directories are laid out as <parent>/<element>/<counter>.
+	if parent, ok := s[filepath.Dir(filepath.Dir(dir))]; ok {
+		for prefix, uri := range parent.namespaces {
+			current.namespaces[prefix] = uri
+		}
+		current.attrs = parent.attrs
+		current.entities = parent.entities
+	}
+	root, err := parseRecord(strings.Join(action.lines, ""), &scope{entities: current.entities})
+	if err != nil {
+		return fmt.Errorf("%s: %v", action.path, err)
+	}
+	for prefix, uri := range root.namespaces {
+		current.namespaces[prefix] = uri
+	}
+	for _, attr := range root.attrs {
+		if attr.Name.Space == "xml" {
+			current.attrs = append([]xml.Attr{attr}, current.attrs...)
+		}
+	}
+	s[dir] = current
+	return nil
+}
+
+// formatWriter renders every record in a recordFormat before passing it on to the next writer.
+// Envelopes are passed on as they are, so they stay root.xml.
+type formatWriter struct {
+	format recordFormat
+	conf   Config
+	source string
+	next   ioActionWriter
+	scopes recordScopes
+}
+
+func (w *formatWriter) write(actions []ioAction) ([]ioAction, error) {
+	var ready []ioAction
+	for len(actions) > 0 && actions[0].ready {
+		action := actions[0]
+		if action.envelope {
+			if err := w.scopes.observe(action); err != nil {
+				return nil, err
+			}
+		}
+		if action.actionType == writeFile && !action.envelope {
+			rec, err := newRecord(w.conf, w.source, action, w.scopes)
+			if err != nil {
+				return nil, err
+			}
+			var content, sidecar []byte
+			var ext string
+			if s, ok := w.format.(sidecarFormat); ok {
+				content, ext, sidecar, err = s.renderWithSidecar(rec)
+			} else {
+				content, err = w.format.render(rec)
+			}
+			if err != nil {
+				return nil, err
+			}
+			base := strings.TrimSuffix(action.path, filepath.Ext(action.path))
+			action.path = base + w.format.extension()
+			action.lines = []string{string(content)}
+			if ext != "" {
+				ready = append(ready, action)
+				action = ioAction{actionType: writeFile, path: base + ext, lines: []string{string(sidecar)}, ready: true, sidecar: true, element: action.element}
+			}
+		}
+		ready = append(ready, action)
+		actions = actions[1:]
+	}
+	if _, err := w.next.write(ready); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+func (w *formatWriter) close() error {
+	return closeWriter(w.next)
+}
+
+func (w *formatWriter) outputs() []output {
+	return listOutputs(w.next)
+}
+
+// streamWriter renders every split record of a source file as a line of a single stream file,
+// <out>/<source><streamExtension>. Envelopes and directories are not written. When a rollover size
+// is configured files are numbered, <out>/<source>.<n><streamExtension>, and a new one is started
+// once the current file holds that many uncompressed bytes.
+type streamWriter struct {
+	format  recordFormat
+	conf    Config
+	source  string
+	file    *outputFile
+	index   int
+	written int64
+	scopes  recordScopes
+	listed  outputList
+}
+
+func newStreamWriter(conf Config, source string, format recordFormat) *streamWriter {
+	return &streamWriter{format: format, conf: conf, source: source, scopes: make(recordScopes)}
+}
+
+func (w *streamWriter) write(actions []ioAction) ([]ioAction, error) {
+	for len(actions) > 0 && actions[0].ready {
+		action := actions[0]
+		var err error
+		if action.envelope {
+			err = w.scopes.observe(action)
+		} else if action.actionType == writeFile {
+			err = w.writeRecord(action)
+		}
+		if err != nil {
+			return nil, err
+		}
+		actions = actions[1:]
+	}
+	return actions, nil
+}
+
+func (w *streamWriter) writeRecord(action ioAction) error {
+	rec, err := newRecord(w.conf, w.source, action, w.scopes)
+	if err != nil {
+		return err
+	}
+	line, err := w.format.renderLine(rec)
+	if err != nil || line == nil {
+		return err
+	}
+	if w.file != nil && w.conf.rollover > 0 && w.written >= w.conf.rollover {
+		if err := w.close(); err != nil {
+			return err
+		}
+		w.file = nil
+		w.index++
+	}
+	if w.file == nil {
+		path := filepath.Join(w.conf.out, sourceName(w.source))
+		if w.conf.rollover > 0 {
+			path += fmt.Sprintf(".%d", w.index)
+		}
+		if w.file, err = createOutputFile(path+w.format.streamExtension(), w.conf.compress, w.conf.compressLevel); err != nil {
+			return err
+		}
+		w.listed.open(w.file.file.Name(), w.file.counter)
+		w.written = 0
+		if h, ok := w.format.(headerFormat); ok {
+			header, err := h.header()
+			if err != nil {
+				return err
+			}
+			if _, err := w.file.Write(append(header, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+	n, err := w.file.Write(append(line, '\n'))
+	w.written += int64(n)
+	w.listed.count(1)
+	return err
+}
+
+func (w *streamWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.listed.close()
+	return err
+}
+
+func (w *streamWriter) outputs() []output {
+	return w.listed.take()
+}
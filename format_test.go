@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FormatSuite struct {
+	tempDirSuite
+}
+
+func TestFormatSuite(t *testing.T) {
+	suite.Run(t, new(FormatSuite))
+}
+
+func (s *FormatSuite) actions() []ioAction {
+	return []ioAction{
+		{actionType: newDirectory, path: s.dir + "/sprot/uniprot/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/root.xml", lines: []string{`<uniprot xmlns="urn:u"/>`}, ready: true, envelope: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/entry.0.xml", lines: []string{"<entry>", "a", "</entry>"}, ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/entry.1.xml", lines: []string{"<entry>"}},
+	}
+}
+
+func (s *FormatSuite) TestFormatWriter() {
+	conf := Config{out: s.dir, format: "json", namespaces: "expand"}
+	w, err := newWriter(conf, "in/sprot.xml")
+	s.Require().NoError(err)
+	remaining, err := w.write(s.actions())
+	s.Require().NoError(err)
+	s.Assert().Len(remaining, 1)
+	s.Require().NoError(closeWriter(w))
+
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "sprot/uniprot/0/entry.0.json"))
+	s.Require().NoError(err)
+	s.Assert().Equal("{\n  \"{urn:u}entry\": \"a\"\n}", string(content))
+	envelope, err := ioutil.ReadFile(filepath.Join(s.dir, "sprot/uniprot/0/root.xml"))
+	s.Require().NoError(err)
+	s.Assert().Equal(`<uniprot xmlns="urn:u"/>`, string(envelope))
+	_, err = os.Stat(filepath.Join(s.dir, "sprot/uniprot/0/root.json"))
+	s.Assert().True(os.IsNotExist(err))
+}
+
+func (s *FormatSuite) TestStreamWriter() {
+	conf := Config{out: s.dir, format: "json", namespaces: "keep", stream: true}
+	w, err := newWriter(conf, "in/sprot.xml")
+	s.Require().NoError(err)
+	remaining, err := w.write(s.actions())
+	s.Require().NoError(err)
+	s.Assert().Len(remaining, 1)
+	s.Require().NoError(closeWriter(w))
+
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "sprot.jsonl"))
+	s.Require().NoError(err)
+	s.Assert().Equal("{\"entry\":\"a\"}\n", string(content))
+	_, err = os.Stat(filepath.Join(s.dir, "sprot"))
+	s.Assert().True(os.IsNotExist(err))
+}
+
+func (s *FormatSuite) TestStreamMissingOutputFolder() {
+	out := filepath.Join(s.dir, "missing", "out")
+	w, err := newWriter(Config{out: out, format: "json", namespaces: "keep", stream: true}, "in/sprot.xml")
+	s.Require().NoError(err)
+	_, err = w.write([]ioAction{{actionType: writeFile, path: out + "/sprot/uniprot/0/entry.0.xml", lines: []string{"<entry>", "a", "</entry>"}, ready: true}})
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	content, err := ioutil.ReadFile(filepath.Join(out, "sprot.jsonl"))
+	s.Require().NoError(err)
+	s.Assert().Equal("{\"entry\":\"a\"}\n", string(content))
+}
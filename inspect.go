@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func inspectCommand(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	in := flags.String("in", "", "the file to inspect, which may be gzipped")
+	asJSON := flags.Bool("json", false, "report in JSON")
+	limit := flags.Int64("limit", 0, "number of bytes to read, after decompression, for a quick look (0 for the whole file)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*in) == 0 {
+		flags.PrintDefaults()
+		return errors.New("a value must be provided for -in")
+	}
+	r, err := openSource(*in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	i, err := inspect(r, *limit)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *in, err)
+	}
+	if *asJSON {
+		content, err := marshalJSON(i.fields(), true)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(content, '\n'))
+		return err
+	}
+	return i.report(os.Stdout)
+}
+
+// pathCount counts the elements at a path from the root.
+type pathCount struct {
+	path  string
+	depth int
+	count int
+}
+
+// sizeDistribution summarises the sizes in bytes of the elements at a depth, which would be the
+// records if the file were split there. Sizes are counted in buckets by the power of two above them.
+type sizeDistribution struct {
+	count   int
+	min     int64
+	max     int64
+	total   int64
+	buckets []int
+}
+
+func (s *sizeDistribution) add(size int64) {
+	if s.count == 0 || size < s.min {
+		s.min = size
+	}
+	if size > s.max {
+		s.max = size
+	}
+	s.count++
+	s.total += size
+	bucket := 0
+	for int64(1)<<uint(bucket) < size {
+		bucket++
+	}
+	for len(s.buckets) <= bucket {
+		s.buckets = append(s.buckets, 0)
+	}
+	s.buckets[bucket]++
+}
+
+func (s *sizeDistribution) mean() int64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.total / int64(s.count)
+}
+
+// namedCount counts the occurrences of an attribute name or namespace declaration.
+type namedCount struct {
+	name  string
+	uri   string
+	count int
+}
+
+// inspection describes the structure of a document, counting elements by path, attributes by name
+// and namespace declarations by prefix and URI, in the order they are first seen.
+type inspection struct {
+	bytes      int64
+	truncated  bool
+	maxDepth   int
+	paths      []*pathCount
+	sizes      []*sizeDistribution
+	attributes []*namedCount
+	namespaces []*namedCount
+	index      map[string]*pathCount
+	names      map[string]*namedCount
+}
+
+// inspect streams the document read from r, stopping after limit bytes if limit is greater than
+// zero. Elements still open when the limit is reached are not included in the size distributions.
+func inspect(r io.Reader, limit int64) (*inspection, error) {
+	limited := &io.LimitedReader{R: r, N: limit}
+	if limit > 0 {
+		r = limited
+	}
+	i := &inspection{index: make(map[string]*pathCount), names: make(map[string]*namedCount)}
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	var paths []string
+	var starts []int64
+	for {
+		start := d.InputOffset()
+		t, err := d.RawToken()
+		if err == io.EOF {
+			i.truncated = len(paths) > 0
+			break
+		}
+		if err != nil {
+			if limit > 0 && limited.N == 0 {
+				i.truncated = true
+				break
+			}
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			path := "/" + qualifiedName(t.Name)
+			if len(paths) > 0 {
+				path = paths[len(paths)-1] + path
+			}
+			depth := len(paths)
+			if depth > i.maxDepth {
+				i.maxDepth = depth
+			}
+			p, ok := i.index[path]
+			if !ok {
+				p = &pathCount{path: path, depth: depth}
+				i.index[path] = p
+				i.paths = append(i.paths, p)
+			}
+			p.count++
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					i.namespaces = i.count(i.namespaces, "xmlns:"+attr.Name.Local, attr.Value)
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					i.namespaces = i.count(i.namespaces, "xmlns", attr.Value)
+				default:
+					i.attributes = i.count(i.attributes, qualifiedName(attr.Name), "")
+				}
+			}
+			paths = append(paths, path)
+			starts = append(starts, start)
+		case xml.EndElement:
+			if len(paths) == 0 {
+				return nil, &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">"}
+			}
+			depth := len(paths) - 1
+			if depth > 0 {
+				for len(i.sizes) <= depth {
+					i.sizes = append(i.sizes, &sizeDistribution{})
+				}
+				i.sizes[depth].add(d.InputOffset() - starts[depth])
+			}
+			paths, starts = paths[:depth], starts[:depth]
+		}
+	}
+	i.bytes = d.InputOffset()
+	return i, nil
+}
+
+// count adds an occurrence of name, declared with uri for namespaces, to counts.
+func (i *inspection) count(counts []*namedCount, name, uri string) []*namedCount {
+	key := name + " " + uri
+	if c, ok := i.names[key]; ok {
+		c.count++
+		return counts
+	}
+	c := &namedCount{name: name, uri: uri, count: 1}
+	i.names[key] = c
+	return append(counts, c)
+}
+
+func (i *inspection) fields() jsonObject {
+	var paths, depths, attributes, namespaces []interface{}
+	for _, p := range i.paths {
+		paths = append(paths, jsonObject{{key: "path", value: p.path}, {key: "depth", value: p.depth}, {key: "count", value: p.count}})
+	}
+	for depth, s := range i.sizes {
+		if depth == 0 {
+			continue
+		}
+		var buckets []interface{}
+		for bucket, n := range s.buckets {
+			if n > 0 {
+				buckets = append(buckets, jsonObject{{key: "max", value: int64(1) << uint(bucket)}, {key: "count", value: n}})
+			}
+		}
+		depths = append(depths, jsonObject{
+			{key: "depth", value: depth},
+			{key: "records", value: s.count},
+			{key: "min", value: s.min},
+			{key: "mean", value: s.mean()},
+			{key: "max", value: s.max},
+			{key: "buckets", value: buckets},
+		})
+	}
+	for _, a := range i.attributes {
+		attributes = append(attributes, jsonObject{{key: "name", value: a.name}, {key: "count", value: a.count}})
+	}
+	for _, n := range i.namespaces {
+		namespaces = append(namespaces, jsonObject{{key: "declaration", value: n.name}, {key: "uri", value: n.uri}, {key: "count", value: n.count}})
+	}
+	return jsonObject{
+		{key: "bytes", value: i.bytes},
+		{key: "truncated", value: i.truncated},
+		{key: "maxDepth", value: i.maxDepth},
+		{key: "paths", value: paths},
+		{key: "depths", value: depths},
+		{key: "attributes", value: attributes},
+		{key: "namespaces", value: namespaces},
+	}
+}
+
+// report writes the inspection as tables for reading.
+func (i *inspection) report(w io.Writer) error {
+	t := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	read := fmt.Sprintf("%d bytes read", i.bytes)
+	if i.truncated {
+		read += ", stopped before the end of the document"
+	}
+	fmt.Fprintf(t, "%s\nmaximum depth %d\n", read, i.maxDepth)
+
+	fmt.Fprintf(t, "\ndepth\tcount\tpath\n")
+	for _, p := range i.paths {
+		fmt.Fprintf(t, "%d\t%d\t%s\n", p.depth, p.count, p.path)
+	}
+
+	fmt.Fprintf(t, "\ndepth\trecords\tmin\tmean\tmax\tsizes\n")
+	for depth, s := range i.sizes {
+		if depth == 0 {
+			continue
+		}
+		var buckets []string
+		for bucket, n := range s.buckets {
+			if n > 0 {
+				buckets = append(buckets, fmt.Sprintf("<=%s:%d", formatSize(int64(1)<<uint(bucket)), n))
+			}
+		}
+		fmt.Fprintf(t, "%d\t%d\t%s\t%s\t%s\t%s\n", depth, s.count, formatSize(s.min), formatSize(s.mean()), formatSize(s.max), strings.Join(buckets, " "))
+	}
+
+	if len(i.attributes) > 0 {
+		fmt.Fprintf(t, "\ncount\tattribute\n")
+		for _, a := range i.attributes {
+			fmt.Fprintf(t, "%d\t%s\n", a.count, a.name)
+		}
+	}
+	if len(i.namespaces) > 0 {
+		fmt.Fprintf(t, "\ncount\tdeclaration\tnamespace\n")
+		for _, n := range i.namespaces {
+			fmt.Fprintf(t, "%d\t%s\t%s\n", n.count, n.name, n.uri)
+		}
+	}
+	return t.Flush()
+}
+
+// formatSize formats a number of bytes in the largest unit it fills, to one decimal place.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	value, unit := float64(size)/1024, "KB"
+	for _, u := range []string{"MB", "GB"} {
+		if value < 1024 {
+			break
+		}
+		value, unit = value/1024, u
+	}
+	if value == float64(int64(value)) {
+		return fmt.Sprintf("%d%s", int64(value), unit)
+	}
+	return fmt.Sprintf("%.1f%s", value, unit)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type InspectSuite struct {
+	suite.Suite
+}
+
+func TestInspectSuite(t *testing.T) {
+	suite.Run(t, new(InspectSuite))
+}
+
+const inspectSource = `<?xml version="1.0"?>
+<set xmlns="http://example.com/set" xmlns:x="http://example.com/x" version="2">
+<item id="1"><name>a</name></item>
+<item id="2" x:lang="en"><name>bb</name><name>c</name></item>
+</set>
+`
+
+func (s *InspectSuite) TestInspect() {
+	i, err := inspect(strings.NewReader(inspectSource), 0)
+	s.Require().NoError(err)
+	s.Equal(int64(len(inspectSource)), i.bytes)
+	s.False(i.truncated)
+	s.Equal(2, i.maxDepth)
+	s.Equal([]*pathCount{
+		{path: "/set", depth: 0, count: 1},
+		{path: "/set/item", depth: 1, count: 2},
+		{path: "/set/item/name", depth: 2, count: 3},
+	}, i.paths)
+	s.Equal([]*sizeDistribution{
+		{count: 2, min: 34, max: 61, total: 95, buckets: []int{0, 0, 0, 0, 0, 0, 2}},
+		{count: 3, min: 14, max: 15, total: 43, buckets: []int{0, 0, 0, 0, 3}},
+	}, i.sizes[1:])
+	s.Equal([]*namedCount{
+		{name: "version", count: 1},
+		{name: "id", count: 2},
+		{name: "x:lang", count: 1},
+	}, i.attributes)
+	s.Equal([]*namedCount{
+		{name: "xmlns", uri: "http://example.com/set", count: 1},
+		{name: "xmlns:x", uri: "http://example.com/x", count: 1},
+	}, i.namespaces)
+}
+
+func (s *InspectSuite) TestInspectLimit() {
+	for _, limit := range []int64{10, 80, 170} {
+		i, err := inspect(strings.NewReader(inspectSource), limit)
+		s.Require().NoError(err)
+		s.True(i.truncated)
+		s.True(i.bytes <= limit)
+	}
+	i, err := inspect(strings.NewReader(inspectSource), 170)
+	s.Require().NoError(err)
+	s.Equal(2, i.index["/set/item"].count)
+	s.Equal(1, i.sizes[1].count)
+
+	_, err = inspect(strings.NewReader("<set></item></set>"), 0)
+	s.Error(err)
+}
+
+func (s *InspectSuite) TestReport() {
+	i, err := inspect(strings.NewReader(inspectSource), 0)
+	s.Require().NoError(err)
+	var b bytes.Buffer
+	s.Require().NoError(i.report(&b))
+	s.Equal(`206 bytes read
+maximum depth 2
+
+depth  count  path
+0      1      /set
+1      2      /set/item
+2      3      /set/item/name
+
+depth  records  min  mean  max  sizes
+1      2        34B  47B   61B  <=64B:2
+2      3        14B  14B   15B  <=16B:3
+
+count  attribute
+1      version
+2      id
+1      x:lang
+
+count  declaration  namespace
+1      xmlns        http://example.com/set
+1      xmlns:x      http://example.com/x
+`, b.String())
+
+	content, err := marshalJSON(i.fields(), false)
+	s.Require().NoError(err)
+	s.Contains(string(content), `"depths":[{"depth":1,"records":2,"min":34,"mean":47,"max":61,"buckets":[{"max":64,"count":2}]}`)
+}
+
+func (s *InspectSuite) TestFormatSize() {
+	for size, want := range map[int64]string{0: "0B", 1023: "1023B", 1024: "1KB", 1536: "1.5KB", 5 << 20: "5MB", 3 << 30: "3GB", 2 << 40: "2048GB"} {
+		s.Equal(want, formatSize(size))
+	}
+}
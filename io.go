@@ -3,8 +3,11 @@ package main
 import (
 	"bufio"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"hash"
+	"io"
 	"os"
 	"strings"
 )
@@ -14,6 +17,9 @@ type ioActionType int
 const (
 	writeFile ioActionType = iota
 	newDirectory
+	// openEnvelope carries the start tag of an element above the split depth, whose envelope is only
+	// written once the element ends. Nothing is written for it.
+	openEnvelope
 )
 
 type ioAction struct {
@@ -21,22 +27,189 @@ type ioAction struct {
 	path       string
 	lines      []string
 	ready      bool
+	// envelope marks files holding content above the split depth rather than a split record.
+	envelope bool
+	// sidecar marks files written alongside a record, such as standoff annotations, rather than a record.
+	sidecar bool
+	// provenance is set on records when -provenance is given.
+	provenance *provenance
+	// relation is set on records and the directories of nested elements when -parents is given.
+	relation *relation
+	// element is the name of the root element of a record or envelope.
+	element string
+	// entities are the general entities declared in the DOCTYPE of the source, keyed by name, and
+	// are set on the envelope of the document element.
+	entities map[string]string
 }
 
 type ioActionWriter interface {
 	write([]ioAction) ([]ioAction, error)
 }
 
-type writer struct{}
+// ioActionCloser is implemented by writers that hold resources open across calls to write,
+// such as archives, and must be finalised once a source file has been processed.
+type ioActionCloser interface {
+	close() error
+}
+
+// newWriter returns the ioActionWriter configured for the given source file. The output folder is
+// created here, as archives, streams and sidecars are written straight into it.
+func newWriter(conf Config, source string) (ioActionWriter, error) {
+	if err := os.MkdirAll(conf.out, 0755); err != nil {
+		return nil, err
+	}
+	w, err := newRecordWriter(conf, source)
+	if err != nil {
+		return nil, err
+	}
+	if conf.provenance == "sidecar" {
+		w = &provenanceWriter{conf: conf, source: source, next: w}
+	}
+	if conf.parents {
+		w = &relationWriter{conf: conf, source: source, next: w}
+	}
+	return w, nil
+}
+
+// newRecordWriter returns the ioActionWriter that writes records in the configured format.
+func newRecordWriter(conf Config, source string) (ioActionWriter, error) {
+	format, err := newFormat(conf)
+	if err != nil {
+		return nil, err
+	}
+	if avro, ok := format.(*avroFormat); ok {
+		return newAvroWriter(conf, source, avro), nil
+	}
+	if isStreamed(conf, format) {
+		return newStreamWriter(conf, source, format), nil
+	}
+	sink, err := newSink(conf, source)
+	if err != nil || format == nil {
+		return sink, err
+	}
+	return &formatWriter{format: format, conf: conf, source: source, next: sink, scopes: make(recordScopes)}, nil
+}
+
+// newSink returns the ioActionWriter that writes files to their final destination.
+func newSink(conf Config, source string) (ioActionWriter, error) {
+	switch conf.archive {
+	case "":
+		if conf.compress != "" {
+			return newCompressWriter(conf), nil
+		}
+		return &writer{list: conf.manifest != ""}, nil
+	case "tar", "tar.gz":
+		return newTarWriter(conf, source), nil
+	case "zip":
+		return newZipWriter(conf, source), nil
+	case "pack":
+		return newPackWriter(conf, source), nil
+	}
+	return nil, fmt.Errorf("unknown archive type '%s'", conf.archive)
+}
+
+// closeWriter finalises w if it holds resources open.
+func closeWriter(w ioActionWriter) error {
+	if c, ok := w.(ioActionCloser); ok {
+		return c.close()
+	}
+	return nil
+}
+
+// relativePath is the path generated by processCache relative to the output folder.
+func relativePath(out, path string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(path, out), "/")
+}
+
+// outputFile is a buffered, optionally compressed, file written to by stream outputs.
+type outputFile struct {
+	io.Writer
+	file       *os.File
+	counter    *countingWriter
+	buffered   *bufio.Writer
+	compressor io.WriteCloser
+}
+
+// createOutputFile creates the file at path, appending the extension for the compression format if one is given.
+func createOutputFile(path, compress string, level int) (*outputFile, error) {
+	file, err := os.Create(path + compressionExtensions[compress])
+	if err != nil {
+		return nil, err
+	}
+	f := &outputFile{file: file, counter: newCountingWriter(file)}
+	f.buffered = bufio.NewWriter(f.counter)
+	f.Writer = f.buffered
+	if compress != "" {
+		if f.compressor, err = newCompressor(compress, f.buffered, level); err != nil {
+			file.Close()
+			return nil, err
+		}
+		f.Writer = f.compressor
+	}
+	return f, nil
+}
+
+// size is the number of bytes written to disk so far, excluding any still held in buffers.
+func (f *outputFile) size() int64 {
+	return f.counter.n
+}
+
+func (f *outputFile) Close() error {
+	var err error
+	if f.compressor != nil {
+		err = f.compressor.Close()
+	}
+	if ferr := f.buffered.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// countingWriter tracks the number of bytes written through it and their SHA-256, so files can be
+// listed in the manifest without reading them back.
+type countingWriter struct {
+	w    io.Writer
+	n    int64
+	hash hash.Hash
+}
+
+func newCountingWriter(w io.Writer) *countingWriter {
+	return &countingWriter{w: w, hash: sha256.New()}
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+// checksum returns the hex encoded SHA-256 of the bytes written so far.
+func (c *countingWriter) checksum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+type writer struct {
+	// list is set when the files written are listed for the manifest.
+	list   bool
+	listed outputList
+}
 
 func (w *writer) write(actions []ioAction) ([]ioAction, error) {
 	for len(actions) > 0 && actions[0].ready {
 		action := actions[0]
 		switch action.actionType {
 		case writeFile:
-			if err := ioutil.WriteFile(action.path, []byte(strings.Join(action.lines, "")), 0644); err != nil {
+			stored, err := writeLines(action.path, action.lines)
+			if err != nil {
 				return nil, err
 			}
+			if w.list {
+				w.listed.add(action.path, action, stored)
+			}
 		case newDirectory:
 			if err := os.MkdirAll(action.path, 0755); err != nil {
 				return nil, err
@@ -47,22 +220,76 @@ func (w *writer) write(actions []ioAction) ([]ioAction, error) {
 	return actions, nil
 }
 
-func getScanner(target string, isZipped bool) (*bufio.Scanner, error) {
-	var scanner *bufio.Scanner
+func (w *writer) outputs() []output {
+	return w.listed.take()
+}
+
+// writeLines writes lines to the file at path, returning the count of the bytes written.
+func writeLines(path string, lines []string) (*countingWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	stored := newCountingWriter(file)
+	if _, err := io.WriteString(stored, strings.Join(lines, "")); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return stored, file.Close()
+}
+
+// getScanner returns a scanner over the lines of the file at target. The file is copied to digest,
+// if given, as it is read.
+func getScanner(target string, isZipped bool, digest io.Writer) (*bufio.Scanner, error) {
 	if _, err := os.Stat(target); os.IsNotExist(err) {
 		return nil, fmt.Errorf("File '%s' not Found", target)
 	}
 	file, err := os.Open(target)
 	handleError(err)
 
+	var r io.Reader = file
+	if digest != nil {
+		r = io.TeeReader(file, digest)
+	}
+	scanner, err := newScanner(r, isZipped)
+	handleError(err)
+	return scanner, nil
+}
+
+// newScanner returns a scanner over the lines of r, decompressing them if r is gzipped.
+func newScanner(r io.Reader, isZipped bool) (*bufio.Scanner, error) {
 	if isZipped {
-		gunzip, gerr := gzip.NewReader(file)
-		handleError(gerr)
+		gunzip, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewScanner(bufio.NewReader(gunzip)), nil
+	}
+	return bufio.NewScanner(r), nil
+}
 
-		scanner = bufio.NewScanner(bufio.NewReader(gunzip))
-	} else {
-		scanner = bufio.NewScanner(file)
+// openSource opens the source file at path, decompressing it if it is gzipped.
+func openSource(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gunzip, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
+	return &sourceReader{Reader: gunzip, file: file}, nil
+}
 
-	return scanner, nil
+type sourceReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *sourceReader) Close() error {
+	return r.file.Close()
 }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var xmlDeclaration = regexp.MustCompile(`^\s*<\?xml[^>]*\?>\s*`)
+
+// splitDoctype matches the DOCTYPE declaring entities at the start of files written with -owl.
+var splitDoctype = regexp.MustCompile(`^<!DOCTYPE[^>\[]*\[[^\]]*\]>\s*`)
+
+// placeholderPattern matches the XInclude elements written into envelopes with -envelope placeholders.
+var placeholderPattern = regexp.MustCompile(`<xi:include xmlns:xi="http://www.w3.org/2001/XInclude" href="([^"]*)"/>`)
+
+func joinCommand(args []string) error {
+	flags := flag.NewFlagSet("join", flag.ExitOnError)
+	in := flags.String("in", "", "the split output of a source to join, as the output folder joined with the source name (e.g. out/sprot)")
+	out := flags.String("out", "", "the file to write the joined document to (defaults to stdout)")
+	gzipped := flags.Bool("gzip", false, "gzip the joined document")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*in) == 0 {
+		flags.PrintDefaults()
+		return errors.New("a value must be provided for -in")
+	}
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if len(*out) > 0 {
+		var err error
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	var gz *gzip.Writer
+	if *gzipped {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	if err := joinTree(w, strings.TrimRight(*in, "/")); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if file != nil {
+		return file.Close()
+	}
+	return nil
+}
+
+// joinTree reassembles the document split into dir, as written for a source without -archive or
+// -format, writing it to w. Elements above the split depth are rebuilt from their envelopes. When
+// envelopes hold placeholders their content is restored exactly where it was. Otherwise the records
+// and nested elements inside each element are written in the order of their counters, grouped by
+// name, after any other content of a full envelope.
+func joinTree(w io.Writer, dir string) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	children, err := joinChildren(dir)
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 {
+		return fmt.Errorf("%s holds no split output", dir)
+	}
+	for _, child := range children {
+		if err := joinElement(w, child.path, nil); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// joinChild is a record file or element directory inside the directory of an element.
+type joinChild struct {
+	path    string
+	name    string
+	counter int
+	isDir   bool
+}
+
+// joinChildren lists the records and element directories inside dir, ordered by name and counter.
+// Counters are kept per name, so the order of differently named siblings is lost and only envelopes
+// holding placeholders record it.
+func joinChildren(dir string) ([]joinChild, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var children []joinChild
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			name := strings.TrimSuffix(trimCompression(entry.Name()), ".xml")
+			if name == "root" || name == entry.Name() {
+				continue
+			}
+			child := joinChild{path: path, name: name}
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				if counter, err := strconv.Atoi(name[i+1:]); err == nil {
+					child.name, child.counter = name[:i], counter
+				}
+			}
+			children = append(children, child)
+			continue
+		}
+		counters, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range counters {
+			if counter, err := strconv.Atoi(c.Name()); err == nil && c.IsDir() {
+				children = append(children, joinChild{path: filepath.Join(path, c.Name()), name: entry.Name(), counter: counter, isDir: true})
+			}
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		if children[i].name != children[j].name {
+			return children[i].name < children[j].name
+		}
+		return children[i].counter < children[j].counter
+	})
+	return children, nil
+}
+
+// joinElement writes the element whose envelope and children are in dir, with ancestors the names of
+// the elements enclosing it.
+func joinElement(w io.Writer, dir string, ancestors []string) error {
+	envelope, err := readSplitFile(findSplitFile(dir, "root.xml"))
+	if err != nil {
+		return err
+	}
+	tag := emptyTag.FindStringSubmatch(envelope)
+	open := openingTag.FindStringSubmatch(envelope)
+	var name string
+	switch {
+	case tag != nil && len(tag[0]) == len(envelope):
+		name = tag[1]
+	case open != nil && strings.HasPrefix(envelope, open[0]):
+		name = open[1]
+	default:
+		return fmt.Errorf("%s: no envelope element found", dir)
+	}
+	ancestors = append(ancestors, name)
+
+	if matches := placeholderPattern.FindAllStringSubmatchIndex(envelope, -1); matches != nil {
+		last := 0
+		for _, m := range matches {
+			if _, err := io.WriteString(w, envelope[last:m[0]]); err != nil {
+				return err
+			}
+			if err := joinPlaceholder(w, dir, envelope[m[2]:m[3]], ancestors); err != nil {
+				return err
+			}
+			last = m[1]
+		}
+		_, err := io.WriteString(w, envelope[last:])
+		return err
+	}
+
+	children, err := joinChildren(dir)
+	if err != nil {
+		return err
+	}
+	var content, end string
+	if tag != nil && len(tag[0]) == len(envelope) {
+		if len(children) == 0 {
+			_, err := io.WriteString(w, envelope)
+			return err
+		}
+		content, end = strings.TrimSuffix(strings.TrimSuffix(envelope, "/>"), " ")+">", "</"+name+">"
+	} else {
+		end = "</" + name + ">"
+		content = strings.TrimSuffix(envelope, end)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := joinChildTo(w, child, ancestors); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+// joinPlaceholder writes the file or element a placeholder in the envelope in dir refers to.
+func joinPlaceholder(w io.Writer, dir, href string, ancestors []string) error {
+	path := filepath.Join(dir, filepath.FromSlash(href))
+	if filepath.Base(href) == "root.xml" {
+		return joinElement(w, filepath.Dir(path), ancestors)
+	}
+	return joinChildTo(w, joinChild{path: findSplitFile(filepath.Dir(path), filepath.Base(path))}, ancestors)
+}
+
+func joinChildTo(w io.Writer, child joinChild, ancestors []string) error {
+	if child.isDir {
+		return joinElement(w, child.path, ancestors)
+	}
+	content, err := readSplitFile(child.path)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, unwrapRecord(content, ancestors))
+	return err
+}
+
+// unwrapRecord removes the copies of its ancestors a record was written inside with -wrap or -owl.
+func unwrapRecord(content string, ancestors []string) string {
+	inner := content
+	for _, ancestor := range ancestors {
+		tag := openingTag.FindStringSubmatchIndex(inner)
+		if tag == nil || tag[0] != 0 || inner[tag[2]:tag[3]] != ancestor {
+			return content
+		}
+		inner = inner[tag[1]:]
+	}
+	for _, ancestor := range ancestors {
+		end := "</" + ancestor + ">"
+		if !strings.HasSuffix(inner, end) {
+			return content
+		}
+		inner = strings.TrimSuffix(inner, end)
+	}
+	return inner
+}
+
+// findSplitFile returns the path of the file named name in dir, as written with or without -compress.
+func findSplitFile(dir, name string) string {
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	for _, ext := range compressionExtensions {
+		if _, err := os.Stat(path + ext); err == nil {
+			return path + ext
+		}
+	}
+	return path
+}
+
+// readSplitFile reads a file written by the splitter, decompressing it if it was written with
+// -compress, and returns its content without the XML declaration and, as written with -owl, DOCTYPE.
+func readSplitFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	for format, ext := range compressionExtensions {
+		if strings.HasSuffix(path, ext) {
+			decompressor, err := newDecompressor(format, file)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", path, err)
+			}
+			defer decompressor.Close()
+			r = decompressor
+		}
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	return splitDoctype.ReplaceAllString(xmlDeclaration.ReplaceAllString(string(content), ""), ""), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type JoinSuite struct {
+	tempDirSuite
+}
+
+func TestJoinSuite(t *testing.T) {
+	suite.Run(t, new(JoinSuite))
+}
+
+const joinSource = `<set version="2">Intro<meta/><group id="g"><item>a</item><!-- note --><item n="2"/></group><group id="h"><item>c</item></group></set>`
+
+// join splits joinSource with conf and joins the output back together.
+func (s *JoinSuite) join(conf Config) string {
+	conf.out = s.dir
+	conf.skip = regexp.MustCompile(defaultSkip)
+	conf.strip = regexp.MustCompile("")
+	conf.buffer = 1
+	splitter := XMLSplitter{path: "in/set.xml", conf: conf}
+	w, err := newWriter(conf, splitter.path)
+	s.Require().NoError(err)
+	_, err = splitter.ProcessFile(bufio.NewScanner(strings.NewReader(joinSource)), w)
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	var b bytes.Buffer
+	s.Require().NoError(joinTree(&b, filepath.Join(s.dir, "set")))
+	s.Require().NoError(os.RemoveAll(s.dir))
+	s.Require().True(strings.HasPrefix(b.String(), xml.Header))
+	return strings.TrimSuffix(strings.TrimPrefix(b.String(), xml.Header), "\n")
+}
+
+func (s *JoinSuite) TestJoin() {
+	tests := []struct {
+		name string
+		conf Config
+		want string
+	}{
+		{
+			name: "placeholders",
+			conf: Config{depth: 2, envelope: "placeholders"},
+			want: joinSource,
+		},
+		{
+			name: "compressed placeholders",
+			conf: Config{depth: 2, envelope: "placeholders", compress: "gzip"},
+			want: joinSource,
+		},
+		{
+			name: "stub",
+			conf: Config{depth: 2, envelope: "stub"},
+			want: `<set version="2"><group id="g"><item>a</item><item n="2"/></group><group id="h"><item>c</item></group><meta/></set>`,
+		},
+		{
+			name: "full",
+			conf: Config{depth: 2, envelope: "full"},
+			want: `<set version="2">Intro<group id="g"><!-- note --><item>a</item><item n="2"/></group><group id="h"><item>c</item></group><meta/></set>`,
+		},
+		{
+			name: "wrapped",
+			conf: Config{depth: 2, envelope: "placeholders", wrap: true},
+			want: joinSource,
+		},
+		{
+			name: "depth 1",
+			conf: Config{depth: 1},
+			want: `<set version="2"><group id="g"><item>a</item><!-- note --><item n="2"/></group><group id="h"><item>c</item></group><meta/></set>`,
+		},
+	}
+	for _, tt := range tests {
+		s.Assert().Equal(tt.want, s.join(tt.conf), tt.name)
+	}
+}
+
+func (s *JoinSuite) TestJoinCommand() {
+	dir := filepath.Join(s.dir, "out", "set", "set", "0")
+	s.Require().NoError(os.MkdirAll(dir, 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(dir, "root.xml"), []byte(xml.Header+"<set/>"), 0644))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(dir, "item.0.xml"), []byte(xml.Header+"<item/>"), 0644))
+	path := filepath.Join(s.dir, "set.xml.gz")
+	s.Require().NoError(joinCommand([]string{"-in", filepath.Join(s.dir, "out", "set") + "/", "-out", path, "-gzip"}))
+
+	file, err := os.Open(path)
+	s.Require().NoError(err)
+	defer file.Close()
+	r, err := gzip.NewReader(file)
+	s.Require().NoError(err)
+	content, err := ioutil.ReadAll(r)
+	s.Require().NoError(err)
+	s.Assert().Equal(xml.Header+"<set><item/></set>\n", string(content))
+	s.Assert().Error(joinCommand([]string{"-in", filepath.Join(s.dir, "missing")}))
+}
+
+func (s *JoinSuite) TestUnwrapRecord() {
+	tests := []struct {
+		content   string
+		ancestors []string
+		want      string
+	}{
+		{content: `<a x="1"><b><c/></b></a>`, ancestors: []string{"a", "b"}, want: "<c/>"},
+		{content: `<c/>`, ancestors: []string{"a", "b"}, want: "<c/>"},
+		{content: `<a><c/></a>`, ancestors: []string{"a", "b"}, want: "<a><c/></a>"},
+	}
+	for _, tt := range tests {
+		s.Assert().Equal(tt.want, unwrapRecord(tt.content, tt.ancestors))
+	}
+}
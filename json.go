@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+)
+
+// jsonFormat converts records to JSON. Each record becomes an object with a single key, the record's
+// root element name, whose value is converted as follows:
+//
+//   - attributes become keys prefixed with "@"
+//   - child elements become keys named after the element, with repeated children collected into
+//     an array, as are children whose record-relative path is listed in -json-arrays
+//   - text alongside attributes or child elements is held under "#text"
+//   - elements with only text become a string, and empty elements become null
+//
+// Names are written according to -namespaces: "keep" leaves prefixes as written, "strip" removes
+// them and "expand" replaces them with the namespace URI in {uri}local form. Namespace declarations
+// are only kept as attributes in "keep" mode.
+type jsonFormat struct {
+	arrays     map[string]bool
+	namespaces string
+}
+
+func newJSONFormat(conf Config) *jsonFormat {
+	arrays := make(map[string]bool)
+	for _, path := range conf.jsonArrays {
+		arrays[strings.Trim(path, "/")] = true
+	}
+	return &jsonFormat{arrays: arrays, namespaces: conf.namespaces}
+}
+
+func (f *jsonFormat) extension() string {
+	return ".json"
+}
+
+func (f *jsonFormat) streamExtension() string {
+	return ".jsonl"
+}
+
+func (f *jsonFormat) render(rec record) ([]byte, error) {
+	return marshalJSON(f.document(rec.root), true)
+}
+
+func (f *jsonFormat) renderLine(rec record) ([]byte, error) {
+	return marshalJSON(f.document(rec.root), false)
+}
+
+// document converts a record root into a JSON object keyed by its name.
+func (f *jsonFormat) document(root *node) jsonObject {
+	return jsonObject{{key: f.name(root, root.name, false), value: f.value(root)}}
+}
+
+// value converts an element into its JSON value.
+func (f *jsonFormat) value(n *node) interface{} {
+	var object jsonObject
+	for _, attr := range n.attrs {
+		if isNamespaceDeclaration(attr) && f.namespaces != "keep" {
+			continue
+		}
+		object = append(object, jsonField{key: "@" + f.name(n, attr.Name, true), value: attr.Value})
+	}
+
+	children := n.elements()
+	text := n.ownText()
+	if len(object) == 0 && len(children) == 0 {
+		if text == "" {
+			return nil
+		}
+		return text
+	}
+
+	var keys []string
+	grouped := make(map[string][]interface{})
+	forced := make(map[string]bool)
+	for _, child := range children {
+		key := f.name(child, child.name, false)
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], f.value(child))
+		if f.arrays[child.path()] {
+			forced[key] = true
+		}
+	}
+	for _, key := range keys {
+		if len(grouped[key]) > 1 || forced[key] {
+			object = append(object, jsonField{key: key, value: grouped[key]})
+		} else {
+			object = append(object, jsonField{key: key, value: grouped[key][0]})
+		}
+	}
+	if strings.TrimSpace(text) != "" {
+		object = append(object, jsonField{key: "#text", value: text})
+	}
+	return object
+}
+
+// name converts an element or attribute name according to the namespace mode.
+func (f *jsonFormat) name(n *node, name xml.Name, isAttr bool) string {
+	switch f.namespaces {
+	case "strip":
+		return name.Local
+	case "expand":
+		// Unprefixed attributes are in no namespace, whereas unprefixed elements take the default namespace.
+		if isAttr && name.Space == "" {
+			return name.Local
+		}
+		if uri := n.namespace(name.Space); uri != "" {
+			return "{" + uri + "}" + name.Local
+		}
+	}
+	return qualifiedName(name)
+}
+
+// jsonObject is a JSON object that keeps its fields in document order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := marshalJSON(field.key, false)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(field.value, false)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// marshalJSON encodes v without escaping HTML characters, optionally indented.
+func marshalJSON(v interface{}, indent bool) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if indent {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
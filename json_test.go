@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type JSONSuite struct {
+	suite.Suite
+}
+
+func TestJSONSuite(t *testing.T) {
+	suite.Run(t, new(JSONSuite))
+}
+
+func (s *JSONSuite) TestRenderLine() {
+	tests := []struct {
+		name    string
+		conf    Config
+		content string
+		context *scope
+		want    string
+	}{
+		{
+			name:    "text, attributes and repeated children",
+			conf:    Config{namespaces: "keep"},
+			content: `<entry id="1"><accession>A</accession><accession>B</accession><name>n &amp; m</name><empty/><mixed at="x">text</mixed></entry>`,
+			want:    `{"entry":{"@id":"1","accession":["A","B"],"name":"n & m","empty":null,"mixed":{"@at":"x","#text":"text"}}}`,
+		},
+		{
+			name:    "forced arrays",
+			conf:    Config{namespaces: "keep", jsonArrays: []string{"list/item", "/single/"}},
+			content: `<entry><list><item>1</item></list><single>2</single><other><item>3</item></other></entry>`,
+			want:    `{"entry":{"list":{"item":["1"]},"single":["2"],"other":{"item":"3"}}}`,
+		},
+		{
+			name:    "keep namespaces",
+			conf:    Config{namespaces: "keep"},
+			content: `<rdf:Description xmlns:rdf="urn:rdf" rdf:about="x"><label>l</label></rdf:Description>`,
+			want:    `{"rdf:Description":{"@xmlns:rdf":"urn:rdf","@rdf:about":"x","label":"l"}}`,
+		},
+		{
+			name:    "strip namespaces",
+			conf:    Config{namespaces: "strip"},
+			content: `<rdf:Description xmlns:rdf="urn:rdf" rdf:about="x"><label>l</label></rdf:Description>`,
+			want:    `{"Description":{"@about":"x","label":"l"}}`,
+		},
+		{
+			name:    "expand namespaces",
+			conf:    Config{namespaces: "expand"},
+			content: `<rdf:Description xmlns:rdf="urn:rdf" rdf:about="x" plain="y"><label>l</label><u:label>m</u:label></rdf:Description>`,
+			context: &scope{namespaces: map[string]string{"": "urn:default"}},
+			want:    `{"{urn:rdf}Description":{"@{urn:rdf}about":"x","@plain":"y","{urn:default}label":"l","u:label":"m"}}`,
+		},
+	}
+	for _, tt := range tests {
+		root, err := parseRecord(tt.content, tt.context)
+		s.Require().NoError(err, tt.name)
+		line, err := newJSONFormat(tt.conf).renderLine(record{root: root})
+		s.Require().NoError(err, tt.name)
+		s.Assert().Equal(tt.want, string(line), tt.name)
+	}
+}
+
+func (s *JSONSuite) TestRender() {
+	root, err := parseRecord(`<entry id="1"><name>n</name></entry>`, nil)
+	s.Require().NoError(err)
+	content, err := newJSONFormat(Config{namespaces: "keep"}).render(record{root: root})
+	s.Require().NoError(err)
+	s.Assert().Equal("{\n  \"entry\": {\n    \"@id\": \"1\",\n    \"name\": \"n\"\n  }\n}", string(content))
+}
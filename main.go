@@ -1,50 +1,237 @@
 package main
 
 import (
+	"compress/flate"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"log"
+	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 type Config struct {
-	in    string
-	out   string
-	files int
-	skip  *regexp.Regexp
-	strip *regexp.Regexp
-	depth int
-	buffer int
+	in                  string
+	out                 string
+	files               int
+	skip                *regexp.Regexp
+	strip               *regexp.Regexp
+	depth               int
+	autoDepth           bool
+	depthSample         int64
+	depthConfidence     float64
+	depthFallback       int
+	buffer              int
+	archive             string
+	rollover            int64
+	compress            string
+	compressLevel       int
+	format              string
+	stream              bool
+	jsonArrays          []string
+	namespaces          string
+	bulkIndex           string
+	bulkID              string
+	columns             []string
+	multiple            string
+	multipleSeparator   string
+	eavInclude          *regexp.Regexp
+	eavExclude          *regexp.Regexp
+	textBlocks          []string
+	textSkip            []string
+	owl                 bool
+	owlHeader           bool
+	refs                []string
+	refKeys             []string
+	avroSchema          string
+	avroSample          int
+	avroCodec           string
+	template            string
+	wrap                bool
+	envelope            string
+	provenance          string
+	provenanceNamespace string
+	provenancePrefix    string
+	runID               string
+	parents             bool
+	parentKey           string
+	manifest            string
 }
 
 func GetConfig() (Config, error) {
 	c := Config{}
-	var skip, strip, in, out string
+	var skip, strip, in, out, jsonArrays, columns, eavInclude, eavExclude, textBlocks, textSkip, refs, refKeys string
+	var rollover int64
+	var depth string
 	flag.StringVar(&in, "in", "", "the folder to process (glob)")
 	flag.StringVar(&out, "out", "", "the folder output to")
-	flag.IntVar(&c.depth, "depth", 1, "the nesting depth at which to split the XML")
+	flag.StringVar(&depth, "depth", "1", "the nesting depth at which to split the XML, or auto to detect it for each file")
+	flag.Int64Var(&c.depthSample, "depth-sample", 1024*1024, "number of bytes sampled from the start of each file with -depth auto")
+	flag.Float64Var(&c.depthConfidence, "depth-confidence", 0.8, "the proportion of the content at a depth its records must hold for -depth auto to choose it")
+	flag.IntVar(&c.depthFallback, "depth-fallback", 1, "the depth used when -depth auto finds none with enough confidence")
 	flag.IntVar(&c.files, "files", 1, "number of files to process concurrently")
 	flag.StringVar(&skip, "skip", defaultSkip, "regex for lines that should be skipped")
 	flag.StringVar(&strip, "strip", "", "regex of values to strip from lines")
 	flag.IntVar(&c.buffer, "buffer", 20, "max number of files to hold in buffer before writing")
+	flag.StringVar(&c.archive, "archive", "", "write output into archives rather than loose files (tar, tar.gz, zip, pack)")
+	flag.Int64Var(&rollover, "rollover", 0, "size in MB at which a new archive, pack segment or stream file is started (0 for no limit)")
+	flag.StringVar(&c.compress, "compress", "", "compress each output file (gzip, zlib, flate)")
+	flag.IntVar(&c.compressLevel, "compress-level", flate.DefaultCompression, "compression level from -2 (huffman only) to 9 (best), -1 for the default")
+	flag.StringVar(&c.format, "format", "xml", "the format records are written in (xml, json, bulk, csv, tsv, eav, text, standoff, ntriples, avro, template)")
+	flag.BoolVar(&c.stream, "stream", false, "write records into a single stream file per source rather than a file each")
+	flag.StringVar(&jsonArrays, "json-arrays", "", "comma separated record-relative paths of elements always converted to JSON arrays")
+	flag.StringVar(&c.namespaces, "namespaces", "keep", "how namespace prefixes are written in converted records (keep, strip, expand)")
+	flag.StringVar(&c.bulkIndex, "bulk-index", "", "the _index of bulk actions (defaults to the lowercased source name)")
+	flag.StringVar(&c.bulkID, "bulk-id", "", "record-relative path of the value used as the _id of bulk actions (defaults to the record key)")
+	flag.StringVar(&columns, "columns", "", "comma separated name=path columns extracted by the csv and tsv formats")
+	flag.StringVar(&c.multiple, "multiple", "join", "how columns matching several values are written (join, first)")
+	flag.StringVar(&c.multipleSeparator, "multiple-separator", "; ", "the separator used to join multiple column values")
+	flag.StringVar(&eavInclude, "eav-include", "", "regex of record-relative element paths included by the eav format")
+	flag.StringVar(&eavExclude, "eav-exclude", "", "regex of record-relative element paths excluded by the eav format")
+	flag.StringVar(&textBlocks, "text-blocks", "p,sec,title", "comma separated elements separated by a blank line in extracted text and standoff output")
+	flag.StringVar(&textSkip, "text-skip", "", "comma separated elements left out of extracted text and standoff output")
+	flag.BoolVar(&c.owl, "owl", false, "split an OWL ontology into a file per class, property or individual, wrapped in its rdf:RDF element (implies -depth 1)")
+	flag.BoolVar(&c.owlHeader, "owl-header", false, "copy the owl:Ontology header into every file written with -owl")
+	flag.StringVar(&refs, "refs", "", "comma separated relation=path rules of references written to an edge list per source, 'default' for the built-in rules")
+	flag.StringVar(&refKeys, "ref-keys", "", "comma separated record-relative paths of the values references resolve to (defaults to the built-in keys)")
+	flag.StringVar(&c.avroSchema, "avro-schema", "", "file holding the avro schema records are written with (inferred from a sample by default)")
+	flag.IntVar(&c.avroSample, "avro-sample", 1000, "number of records of each source the avro schema is inferred from")
+	flag.StringVar(&c.avroCodec, "avro-codec", "deflate", "the codec avro blocks are written with (null, deflate)")
+	flag.StringVar(&c.template, "template", "", "text/template file records are rendered through by the template format")
+	flag.BoolVar(&c.wrap, "wrap", false, "wrap every record in copies of its ancestors' start and end tags, so each file is a standalone document")
+	flag.StringVar(&c.envelope, "envelope", "stub", "what is written for elements above the split depth (stub, full, placeholders)")
+	flag.StringVar(&c.provenance, "provenance", "", "record where each record came from as attributes on its root or in a sidecar per source (attributes, sidecar)")
+	flag.StringVar(&c.provenanceNamespace, "provenance-namespace", defaultProvenanceNamespace, "the namespace of provenance and parent attributes")
+	flag.StringVar(&c.provenancePrefix, "provenance-prefix", "xsp", "the namespace prefix of provenance and parent attributes")
+	flag.StringVar(&c.runID, "run-id", "", "the id recorded for this run (generated from the start time by default)")
+	flag.BoolVar(&c.parents, "parents", false, "give every record the key of the element enclosing it and list the relations between them in a file per source")
+	flag.StringVar(&c.parentKey, "parent-key", "", "attribute, such as @id, of elements above the split depth holding the key records are given as their parent (defaults to the element's output directory)")
+	flag.StringVar(&c.manifest, "manifest", "", "write a manifest of every output file and a summary of the run to the output folder (jsonl, csv)")
 	flag.Parse()
 	if len(in) == 0 || len(out) == 0 {
 		flag.PrintDefaults()
 		return Config{}, errors.New("values must be provided for -in and -out")
 	}
+	if depth == "auto" {
+		c.autoDepth = true
+		c.depth = c.depthFallback
+	} else if n, err := strconv.Atoi(depth); err == nil {
+		c.depth = n
+	} else {
+		return Config{}, errors.New("depth must be a number or auto")
+	}
+	if c.owl {
+		c.depth, c.autoDepth = 1, false
+	}
 	if c.depth < 1 {
 		return Config{}, errors.New("depth must be greater than or equal to 1")
 	}
+	if c.depthConfidence <= 0 || c.depthConfidence > 1 {
+		return Config{}, errors.New("depth-confidence must be greater than 0 and at most 1")
+	}
 	c.in = strings.TrimRight(in, "/")
 	c.out = strings.TrimRight(out, "/")
 	c.strip = regexp.MustCompile(strip)
 	c.skip = regexp.MustCompile(skip)
+	c.rollover = rollover * 1024 * 1024
+	c.jsonArrays = splitList(jsonArrays)
+	c.columns = splitList(columns)
+	c.textBlocks = splitList(textBlocks)
+	c.textSkip = splitList(textSkip)
+	c.refs = splitList(refs)
+	c.refKeys = splitList(refKeys)
+	if eavInclude != "" {
+		c.eavInclude = regexp.MustCompile(eavInclude)
+	}
+	if eavExclude != "" {
+		c.eavExclude = regexp.MustCompile(eavExclude)
+	}
+	switch c.archive {
+	case "", "tar", "tar.gz", "zip", "pack":
+	default:
+		return Config{}, fmt.Errorf("unknown archive type '%s'", c.archive)
+	}
+	if _, ok := compressionExtensions[c.compress]; c.compress != "" && !ok {
+		return Config{}, fmt.Errorf("unknown compression format '%s'", c.compress)
+	}
+	if c.compress != "" && c.archive != "" {
+		return Config{}, errors.New("-compress cannot be combined with -archive")
+	}
+	format, err := newFormat(c)
+	if err != nil {
+		return Config{}, err
+	}
+	if _, ok := format.(*avroFormat); ok && c.compress != "" {
+		return Config{}, errors.New("-compress cannot be combined with -format avro, use -avro-codec")
+	}
+	if c.stream && format == nil {
+		return Config{}, errors.New("-stream requires a -format other than xml")
+	}
+	if isStreamed(c, format) && c.archive != "" {
+		return Config{}, errors.New("-archive cannot be combined with stream output")
+	}
+	switch c.namespaces {
+	case "keep", "strip", "expand":
+	default:
+		return Config{}, fmt.Errorf("unknown namespace mode '%s'", c.namespaces)
+	}
+	switch c.envelope {
+	case "stub", "full", "placeholders":
+	default:
+		return Config{}, fmt.Errorf("unknown envelope mode '%s'", c.envelope)
+	}
+	if c.envelope == "placeholders" && (c.compress != "" || format != nil) {
+		return Config{}, errors.New("-envelope placeholders can only be used with uncompressed -format xml, as the placeholders include .xml files")
+	}
+	switch c.manifest {
+	case "", "jsonl", "csv":
+	default:
+		return Config{}, fmt.Errorf("unknown manifest format '%s'", c.manifest)
+	}
+	switch c.provenance {
+	case "", "attributes", "sidecar":
+	default:
+		return Config{}, fmt.Errorf("unknown provenance mode '%s'", c.provenance)
+	}
+	if c.wrap && format != nil {
+		return Config{}, errors.New("-wrap can only be used with -format xml")
+	}
+	if c.owlHeader && !c.owl {
+		return Config{}, errors.New("-owl-header requires -owl")
+	}
+	if len(c.refKeys) > 0 && len(c.refs) == 0 {
+		return Config{}, errors.New("-ref-keys requires -refs")
+	}
+	if c.parentKey != "" && !c.parents {
+		return Config{}, errors.New("-parent-key requires -parents")
+	}
+	// The key is taken as the start tag is read, before any of the element's children.
+	if path, attr := splitAttributePath(c.parentKey); c.parentKey != "" && (attr == "" || strings.Trim(path, "/.") != "") {
+		return Config{}, errors.New("-parent-key must be an attribute of the element, such as @id")
+	}
+	if c.compressLevel < flate.HuffmanOnly || c.compressLevel > flate.BestCompression {
+		return Config{}, errors.New("compress-level must be between -2 and 9")
+	}
 	return c, nil
 }
 
+// splitList splits a comma separated flag value, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Generic function to handle errors
 func handleError(err error) {
 	if err != nil {
@@ -68,26 +255,113 @@ func getFiles(directory string) []string {
 	return files
 }
 
+// version is recorded in manifests, and can be set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// commands are the subcommands available in addition to splitting, keyed by name.
+var commands = map[string]func(args []string) error{
+	"get":     getCommand,
+	"inspect": inspectCommand,
+	"join":    joinCommand,
+	"merge":   mergeCommand,
+	"verify":  verifyCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	config, err := GetConfig()
 	if err != nil {
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
+	graph := newReferenceGraph(config)
+	manifest := newManifest(config, flagSettings())
+	r, err := newRun(config.runID)
+	handleError(err)
 	files := getFiles(config.in)
 	fileSem := make(chan bool, config.files)
+	var failed int32
 	for _, path := range files {
 		fileSem <- true
 		go func(path string) {
-			s := XMLSplitter{path: path, conf: config}
-			scanner, err := getScanner(s.path, strings.HasSuffix(s.path, ".gz"))
-			handleError(err)
-			filesCreated := s.ProcessFile(scanner, &writer{})
-			fmt.Printf("%d files generated from %s\n", filesCreated, path)
+			documents, err := splitFile(config, path, r, graph, manifest)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error splitting %s: %v\n", path, err)
+				atomic.StoreInt32(&failed, 1)
+			} else {
+				// Streams, archives and avro files hold many of these, so they are not counted as files.
+				fmt.Printf("%d records and envelopes split from %s\n", documents, path)
+			}
 			<-fileSem
 		}(path)
 	}
 	for i := 0; i < cap(fileSem); i++ {
 		fileSem <- true
 	}
+
+	if graph != nil {
+		dangling, err := graph.report(config.out)
+		handleError(err)
+		fmt.Printf("%d dangling references written to %s\n", dangling, filepath.Join(config.out, danglingReferencesFile))
+		duplicates, err := graph.reportDuplicates(config.out)
+		handleError(err)
+		if duplicates > 0 {
+			fmt.Printf("warning: %d keys declared by more than one record written to %s\n", duplicates, filepath.Join(config.out, duplicateKeysFile))
+		}
+		if manifest != nil {
+			handleError(manifest.add(&manifestSource{}, graph.outputs(), nil))
+		}
+	}
+	if manifest != nil {
+		path, err := manifest.write()
+		handleError(err)
+		fmt.Printf("manifest written to %s\n", path)
+	}
+	if atomic.LoadInt32(&failed) != 0 {
+		os.Exit(1)
+	}
+}
+
+// splitFile splits the source file at path, returning the number of records and envelopes split from it.
+func splitFile(config Config, path string, r *run, graph *referenceGraph, manifest *manifest) (int, error) {
+	conf, err := resolveDepth(config, path)
+	if err != nil {
+		return 0, err
+	}
+	s := XMLSplitter{path: path, conf: conf, run: r}
+	// The source is hashed as it is scanned, so its checksum can be listed in the manifest.
+	var digest hash.Hash
+	if manifest != nil {
+		digest = sha256.New()
+	}
+	scanner, err := getScanner(s.path, strings.HasSuffix(s.path, ".gz"), digest)
+	if err != nil {
+		return 0, err
+	}
+	w, err := newWriter(conf, s.path)
+	if err != nil {
+		return 0, err
+	}
+	if graph != nil {
+		w = newReferenceWriter(conf, s.path, graph, w)
+	}
+	if manifest != nil {
+		w = manifest.writer(s.path, w, digest)
+	}
+	documents, err := s.ProcessFile(scanner, w)
+	if err != nil {
+		closeWriter(w)
+		return 0, err
+	}
+	return documents, closeWriter(w)
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// output is a file written for a source, with the element and number of records it holds.
+type output struct {
+	path    string
+	element string
+	records int
+	// stored counts the bytes of the file as they are written to disk. It is only kept while the
+	// file is open; once closed the entry holds just its size and checksum.
+	stored   *countingWriter
+	size     int64
+	checksum string
+}
+
+// finish returns o with the size and checksum of the bytes stored, releasing its counter.
+func (o output) finish() output {
+	if o.stored != nil {
+		o.size, o.checksum, o.stored = o.stored.n, o.stored.checksum(), nil
+	}
+	return o
+}
+
+// outputList is kept by writers to list the files they write for the manifest. Only the file
+// listed last can still be open.
+type outputList []output
+
+// add lists a file holding the content of a single action, once it has been written. Envelopes
+// and sidecars hold no records.
+func (l *outputList) add(path string, action ioAction, stored *countingWriter) {
+	o := output{path: path, element: action.element, stored: stored}
+	if !action.envelope && !action.sidecar {
+		o.records = 1
+	}
+	*l = append(*l, o.finish())
+}
+
+// open lists a file that records will be counted into as they are written.
+func (l *outputList) open(path string, stored *countingWriter) {
+	*l = append(*l, output{path: path, stored: stored})
+}
+
+// count adds n records to the file listed last.
+func (l outputList) count(n int) {
+	l[len(l)-1].records += n
+}
+
+// close finishes the file listed last once it has been closed.
+func (l outputList) close() {
+	if len(l) > 0 {
+		l[len(l)-1] = l[len(l)-1].finish()
+	}
+}
+
+// take removes and returns the files listed that have been closed.
+func (l *outputList) take() []output {
+	taken := *l
+	if n := len(taken); n > 0 && taken[n-1].stored != nil {
+		taken, *l = taken[:n-1], outputList{taken[n-1]}
+	} else {
+		*l = nil
+	}
+	return taken
+}
+
+// outputLister is implemented by writers that can list the files they have written. Each call
+// returns the files closed since the last one.
+type outputLister interface {
+	outputs() []output
+}
+
+// listOutputs returns the files written by w, if it lists them.
+func listOutputs(w ioActionWriter) []output {
+	if l, ok := w.(outputLister); ok {
+		return l.outputs()
+	}
+	return nil
+}
+
+// manifestEntry describes an output file in the manifest.
+type manifestEntry struct {
+	path     string
+	source   string
+	key      string
+	element  string
+	size     int64
+	records  int
+	checksum string
+}
+
+func (e manifestEntry) fields() jsonObject {
+	return jsonObject{
+		{key: "path", value: e.path},
+		{key: "source", value: e.source},
+		{key: "key", value: e.key},
+		{key: "element", value: e.element},
+		{key: "size", value: e.size},
+		{key: "records", value: e.records},
+		{key: "sha256", value: e.checksum},
+	}
+}
+
+// manifestSource totals the output of a source file, with the checksum of the source as stored.
+type manifestSource struct {
+	source   string
+	files    int
+	records  int
+	size     int64
+	checksum string
+}
+
+func (s manifestSource) fields() jsonObject {
+	return jsonObject{
+		{key: "source", value: s.source},
+		{key: "files", value: s.files},
+		{key: "records", value: s.records},
+		{key: "size", value: s.size},
+		{key: "sha256", value: s.checksum},
+	}
+}
+
+// manifest lists every file written in a run, with a summary of the run, in <out>/manifest.jsonl or
+// <out>/manifest.csv. Rather than being held in memory, each entry is appended to a spool file as soon
+// as its file is closed. At the end of the run the summary is written followed by the entries, ordered
+// by source and then in the order their files were closed.
+type manifest struct {
+	mutex    sync.Mutex
+	format   string
+	out      string
+	settings jsonObject
+	start    time.Time
+	sources  []manifestSource
+	spool    *os.File
+	spooled  int64
+	spans    []manifestSpan
+}
+
+// manifestSpan locates the entries of a source in the spool.
+type manifestSpan struct {
+	source string
+	offset int64
+	length int64
+}
+
+// newManifest returns the manifest of a run made with the given settings, or nil if -manifest is not given.
+func newManifest(conf Config, settings jsonObject) *manifest {
+	if conf.manifest == "" {
+		return nil
+	}
+	return &manifest{format: conf.manifest, out: conf.out, settings: settings, start: time.Now()}
+}
+
+// flagSettings returns the value of every flag, including defaults, as the configuration of a run.
+func flagSettings() jsonObject {
+	var settings jsonObject
+	flag.VisitAll(func(f *flag.Flag) {
+		settings = append(settings, jsonField{key: f.Name, value: f.Value.String()})
+	})
+	return settings
+}
+
+// writer returns w wrapped so the files it writes for source are added to the manifest as they are
+// closed. digest is fed the source as it is read, and gives its checksum once it is closed.
+func (m *manifest) writer(source string, w ioActionWriter, digest hash.Hash) ioActionWriter {
+	return &manifestWriter{manifest: m, next: w, digest: digest, total: manifestSource{source: source}, seen: make(map[string]bool)}
+}
+
+// add spools the entries of files written for a source as they are closed, adding them to its
+// total. Files written for the run as a whole are added with an empty source.
+func (m *manifest) add(total *manifestSource, outputs []output, elements []string) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	for _, o := range outputs {
+		o = o.finish()
+		if o.checksum == "" {
+			return fmt.Errorf("%s: not counted as it was written", o.path)
+		}
+		path := relativePath(m.out, o.path)
+		element := o.element
+		if element == "" && o.records > 0 {
+			element = strings.Join(elements, ",")
+		}
+		e := manifestEntry{path: path, source: total.source, key: outputKey(path), element: element, size: o.size, records: o.records, checksum: o.checksum}
+		if err := m.writeEntry(&b, e); err != nil {
+			return err
+		}
+		total.files++
+		total.size += o.size
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.spool == nil {
+		var err error
+		if m.spool, err = ioutil.TempFile(m.out, "manifest.*.spool"); err != nil {
+			return err
+		}
+	}
+	if _, err := m.spool.Write(b.Bytes()); err != nil {
+		return err
+	}
+	// Sources are split concurrently, so a span only grows while no other source has spooled since.
+	if n := len(m.spans); n > 0 && m.spans[n-1].source == total.source && m.spans[n-1].offset+m.spans[n-1].length == m.spooled {
+		m.spans[n-1].length += int64(b.Len())
+	} else {
+		m.spans = append(m.spans, manifestSpan{source: total.source, offset: m.spooled, length: int64(b.Len())})
+	}
+	m.spooled += int64(b.Len())
+	return nil
+}
+
+// total records the totals of a source once all its files have been added.
+func (m *manifest) total(total manifestSource) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sources = append(m.sources, total)
+}
+
+// write writes the manifest, copying the spooled entries after the summary, and returns its path.
+// The spool is removed, so the manifest can only be written once.
+func (m *manifest) write() (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.spool != nil {
+		defer os.Remove(m.spool.Name())
+		defer m.spool.Close()
+	}
+	sort.Slice(m.sources, func(i, j int) bool { return m.sources[i].source < m.sources[j].source })
+	sort.SliceStable(m.spans, func(i, j int) bool { return m.spans[i].source < m.spans[j].source })
+	var sources []interface{}
+	for _, s := range m.sources {
+		sources = append(sources, s.fields())
+	}
+	summary := jsonObject{
+		{key: "version", value: version},
+		{key: "config", value: m.settings},
+		{key: "start", value: m.start.UTC().Format(time.RFC3339)},
+		{key: "end", value: time.Now().UTC().Format(time.RFC3339)},
+		{key: "sources", value: sources},
+	}
+
+	path := filepath.Join(m.out, "manifest."+m.format)
+	file, err := createOutputFile(path, "", 0)
+	if err != nil {
+		return "", err
+	}
+	if m.format == "csv" {
+		err = m.writeCSV(file, summary)
+	} else {
+		err = m.writeJSONL(file, summary)
+	}
+	for _, span := range m.spans {
+		if err == nil {
+			_, err = io.Copy(file, io.NewSectionReader(m.spool, span.offset, span.length))
+		}
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return path, err
+}
+
+// writeJSONL writes the summary as the first line, typed run. The entries follow it as lines typed file.
+func (m *manifest) writeJSONL(w io.Writer, summary jsonObject) error {
+	content, err := marshalJSON(append(jsonObject{{key: "type", value: "run"}}, summary...), false)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+// writeCSV writes the summary as comment lines starting with #, each a field of the summary in
+// JSON, followed by the header row the entries follow.
+func (m *manifest) writeCSV(w io.Writer, summary jsonObject) error {
+	for _, field := range summary {
+		content, err := marshalJSON(field.value, false)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "# "+field.key+": "+string(content)+"\n"); err != nil {
+			return err
+		}
+	}
+	var header []string
+	for _, field := range (manifestEntry{}).fields() {
+		header = append(header, field.key)
+	}
+	row, err := (&csvFormat{comma: ','}).row(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(row, '\n'))
+	return err
+}
+
+// writeEntry writes an entry as a JSONL line typed file or a CSV row.
+func (m *manifest) writeEntry(w io.Writer, e manifestEntry) error {
+	var line []byte
+	var err error
+	if m.format == "csv" {
+		var values []string
+		for _, field := range e.fields() {
+			values = append(values, fmt.Sprint(field.value))
+		}
+		line, err = (&csvFormat{comma: ','}).row(values)
+	} else {
+		line, err = marshalJSON(append(jsonObject{{key: "type", value: "file"}}, e.fields()...), false)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// outputKey is the key of an output file: its path relative to the output folder without its
+// extension, or any compression extension.
+func outputKey(path string) string {
+	path = trimCompression(path)
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// manifestWriter counts the records of a source file and adds the files written for it to the
+// manifest as they are closed.
+type manifestWriter struct {
+	manifest *manifest
+	next     ioActionWriter
+	digest   hash.Hash
+	total    manifestSource
+	elements []string
+	seen     map[string]bool
+}
+
+func (w *manifestWriter) write(actions []ioAction) ([]ioAction, error) {
+	for _, action := range actions {
+		if !action.ready {
+			break
+		}
+		if action.actionType != writeFile || action.envelope {
+			continue
+		}
+		w.total.records++
+		if !w.seen[action.element] {
+			w.seen[action.element] = true
+			w.elements = append(w.elements, action.element)
+		}
+	}
+	remaining, err := w.next.write(actions)
+	if err != nil {
+		return nil, err
+	}
+	return remaining, w.manifest.add(&w.total, listOutputs(w.next), w.elements)
+}
+
+func (w *manifestWriter) close() error {
+	if err := closeWriter(w.next); err != nil {
+		return err
+	}
+	if err := w.manifest.add(&w.total, listOutputs(w.next), w.elements); err != nil {
+		return err
+	}
+	if w.digest != nil {
+		w.total.checksum = hex.EncodeToString(w.digest.Sum(nil))
+	}
+	w.manifest.total(w.total)
+	return nil
+}
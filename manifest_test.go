@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ManifestSuite struct {
+	tempDirSuite
+}
+
+func TestManifestSuite(t *testing.T) {
+	suite.Run(t, new(ManifestSuite))
+}
+
+func (s *ManifestSuite) actions() []ioAction {
+	return []ioAction{
+		{actionType: newDirectory, path: s.dir + "/set/set/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/set/set/0/root.xml", lines: []string{"<set/>"}, ready: true, envelope: true, element: "set"},
+		{actionType: writeFile, path: s.dir + "/set/set/0/item.0.xml", lines: []string{"<item>a</item>"}, ready: true, element: "item"},
+		{actionType: writeFile, path: s.dir + "/set/set/0/item.1.xml", lines: []string{"<item>b</item>"}, ready: true, element: "item"},
+	}
+}
+
+// split writes actions for in/set.xml through the manifest and returns the entries it writes.
+func (s *ManifestSuite) split(conf Config) []manifestEntry {
+	m := newManifest(conf, nil)
+	w, err := newWriter(conf, "in/set.xml")
+	s.Require().NoError(err)
+	digest := sha256.New()
+	w = m.writer("in/set.xml", w, digest)
+	remaining, err := w.write(s.actions())
+	s.Require().NoError(err)
+	s.Require().Empty(remaining)
+	_, err = io.WriteString(digest, "<set/>")
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+	entries := s.entries(m)
+	source := sha256.Sum256([]byte("<set/>"))
+	s.Assert().Equal([]manifestSource{{source: "in/set.xml", files: len(entries), records: 2, size: m.sources[0].size, checksum: hex.EncodeToString(source[:])}}, m.sources)
+	for _, e := range entries {
+		content, err := ioutil.ReadFile(filepath.Join(s.dir, e.path))
+		s.Require().NoError(err)
+		sum := sha256.Sum256(content)
+		s.Assert().Equal(hex.EncodeToString(sum[:]), e.checksum, e.path)
+		s.Assert().Equal(int64(len(content)), e.size, e.path)
+	}
+	return entries
+}
+
+// entries writes a JSONL manifest and reads back its entries, checking the spool is removed.
+func (s *ManifestSuite) entries(m *manifest) []manifestEntry {
+	path, err := m.write()
+	s.Require().NoError(err)
+	spools, err := filepath.Glob(filepath.Join(s.dir, "*.spool"))
+	s.Require().NoError(err)
+	s.Assert().Empty(spools)
+	content, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	var entries []manifestEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n")[1:] {
+		var e map[string]interface{}
+		s.Require().NoError(json.Unmarshal([]byte(line), &e))
+		s.Assert().Equal("file", e["type"])
+		entries = append(entries, manifestEntry{
+			path:     e["path"].(string),
+			source:   e["source"].(string),
+			key:      e["key"].(string),
+			element:  e["element"].(string),
+			size:     int64(e["size"].(float64)),
+			records:  int(e["records"].(float64)),
+			checksum: e["sha256"].(string),
+		})
+	}
+	return entries
+}
+
+func (s *ManifestSuite) TestFiles() {
+	entries := s.split(Config{out: s.dir, manifest: "jsonl"})
+	for i := range entries {
+		entries[i].checksum, entries[i].size = "", 0
+	}
+	s.Assert().Equal([]manifestEntry{
+		{path: "set/set/0/root.xml", source: "in/set.xml", key: "set/set/0/root", element: "set"},
+		{path: "set/set/0/item.0.xml", source: "in/set.xml", key: "set/set/0/item.0", element: "item", records: 1},
+		{path: "set/set/0/item.1.xml", source: "in/set.xml", key: "set/set/0/item.1", element: "item", records: 1},
+	}, entries)
+}
+
+func (s *ManifestSuite) TestContainers() {
+	tests := []struct {
+		conf Config
+		want []manifestEntry
+	}{
+		{
+			conf: Config{archive: "tar.gz", parents: true},
+			want: []manifestEntry{
+				{path: "set.0.tar.gz", source: "in/set.xml", key: "set.0", element: "item", records: 2},
+				{path: "set.relations.tsv", source: "in/set.xml", key: "set.relations"},
+			},
+		},
+		{
+			conf: Config{archive: "pack"},
+			want: []manifestEntry{
+				{path: "set.0.pack", source: "in/set.xml", key: "set.0", element: "item", records: 2},
+				{path: "set.pack.idx", source: "in/set.xml", key: "set.pack"},
+			},
+		},
+		{
+			conf: Config{format: "json", stream: true, compress: "gzip", namespaces: "keep"},
+			want: []manifestEntry{
+				{path: "set.jsonl.gz", source: "in/set.xml", key: "set", element: "item", records: 2},
+			},
+		},
+		{
+			conf: Config{format: "standoff", archive: "zip", namespaces: "keep"},
+			want: []manifestEntry{
+				{path: "set.0.zip", source: "in/set.xml", key: "set.0", element: "item", records: 2},
+			},
+		},
+		{
+			conf: Config{format: "standoff", namespaces: "keep"},
+			want: []manifestEntry{
+				{path: "set/set/0/root.xml", source: "in/set.xml", key: "set/set/0/root", element: "set"},
+				{path: "set/set/0/item.0.txt", source: "in/set.xml", key: "set/set/0/item.0", element: "item", records: 1},
+				{path: "set/set/0/item.0.ann.jsonl", source: "in/set.xml", key: "set/set/0/item.0.ann", element: "item"},
+				{path: "set/set/0/item.1.txt", source: "in/set.xml", key: "set/set/0/item.1", element: "item", records: 1},
+				{path: "set/set/0/item.1.ann.jsonl", source: "in/set.xml", key: "set/set/0/item.1.ann", element: "item"},
+			},
+		},
+		{
+			conf: Config{compress: "gzip", buffer: 1},
+			want: []manifestEntry{
+				{path: "set/set/0/root.xml.gz", source: "in/set.xml", key: "set/set/0/root", element: "set"},
+				{path: "set/set/0/item.0.xml.gz", source: "in/set.xml", key: "set/set/0/item.0", element: "item", records: 1},
+				{path: "set/set/0/item.1.xml.gz", source: "in/set.xml", key: "set/set/0/item.1", element: "item", records: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		s.Require().NoError(os.MkdirAll(filepath.Join(s.dir, "set/set/0"), 0755))
+		tt.conf.out, tt.conf.manifest = s.dir, "jsonl"
+		entries := s.split(tt.conf)
+		for i := range entries {
+			entries[i].checksum, entries[i].size = "", 0
+		}
+		s.Assert().ElementsMatch(tt.want, entries)
+		s.Require().NoError(os.RemoveAll(s.dir))
+	}
+}
+
+func (s *ManifestSuite) TestWrite() {
+	conf := Config{out: s.dir, manifest: "jsonl"}
+	m := newManifest(conf, jsonObject{{key: "depth", value: "1"}})
+	for _, name := range []string{"b", "a"} {
+		stored, err := writeLines(filepath.Join(s.dir, name+".xml"), []string{"<" + name + "/>"})
+		s.Require().NoError(err)
+		total := manifestSource{source: "in/" + name + ".xml", records: 1}
+		s.Require().NoError(m.add(&total, []output{{path: filepath.Join(s.dir, name+".xml"), element: name, records: 1, stored: stored}}, []string{name}))
+		m.total(total)
+	}
+	s.Require().NoError(m.add(&manifestSource{}, nil, nil))
+
+	path, err := m.write()
+	s.Require().NoError(err)
+	s.Assert().Equal(filepath.Join(s.dir, "manifest.jsonl"), path)
+	content, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	s.Require().Len(lines, 3)
+	var summary map[string]interface{}
+	s.Require().NoError(json.Unmarshal([]byte(lines[0]), &summary))
+	s.Assert().Equal("run", summary["type"])
+	s.Assert().Equal(version, summary["version"])
+	s.Assert().Equal(map[string]interface{}{"depth": "1"}, summary["config"])
+	s.Assert().NotEmpty(summary["start"])
+	s.Assert().NotEmpty(summary["end"])
+	s.Assert().Equal([]interface{}{
+		map[string]interface{}{"source": "in/a.xml", "files": 1.0, "records": 1.0, "size": 4.0, "sha256": ""},
+		map[string]interface{}{"source": "in/b.xml", "files": 1.0, "records": 1.0, "size": 4.0, "sha256": ""},
+	}, summary["sources"])
+	s.Assert().True(strings.HasPrefix(lines[1], `{"type":"file","path":"a.xml","source":"in/a.xml","key":"a","element":"a","size":4,"records":1,"sha256":"`), lines[1])
+
+	m = newManifest(Config{out: s.dir, manifest: "csv"}, jsonObject{{key: "depth", value: "1"}})
+	stored, err := writeLines(filepath.Join(s.dir, "a.xml"), []string{"<a/>"})
+	s.Require().NoError(err)
+	s.Require().NoError(m.add(&manifestSource{source: "in/a.xml"}, []output{{path: filepath.Join(s.dir, "a.xml"), element: "a", records: 1, stored: stored}}, []string{"a"}))
+	path, err = m.write()
+	s.Require().NoError(err)
+	content, err = ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	lines = strings.Split(strings.TrimSpace(string(content)), "\n")
+	s.Require().Len(lines, 7)
+	s.Assert().Equal(`# version: "`+version+`"`, lines[0])
+	s.Assert().Equal(`# config: {"depth":"1"}`, lines[1])
+	s.Assert().Equal("path,source,key,element,size,records,sha256", lines[5])
+	s.Assert().True(strings.HasPrefix(lines[6], "a.xml,in/a.xml,a,a,4,1,"))
+}
+
+func (s *ManifestSuite) TestOutputList() {
+	var l outputList
+	l.add("a.xml", ioAction{element: "a"}, newCountingWriter(ioutil.Discard))
+	stored := newCountingWriter(ioutil.Discard)
+	l.open("b.tar", stored)
+	l.count(2)
+	taken := l.take()
+	s.Require().Len(taken, 1)
+	s.Assert().Equal("a.xml", taken[0].path)
+	s.Assert().Nil(taken[0].stored)
+	s.Assert().Equal("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", taken[0].checksum)
+
+	_, err := stored.Write([]byte("b"))
+	s.Require().NoError(err)
+	s.Assert().Empty(l.take())
+	l.close()
+	taken = l.take()
+	s.Require().Len(taken, 1)
+	s.Assert().Equal(output{path: "b.tar", records: 2, size: 1, checksum: "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d"}, taken[0])
+	s.Assert().Empty(l)
+}
+
+func (s *ManifestSuite) TestOutputKey() {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "sprot/uniprot/0/entry.0.xml", want: "sprot/uniprot/0/entry.0"},
+		{path: "sprot/uniprot/0/entry.0.json.gz", want: "sprot/uniprot/0/entry.0"},
+		{path: "sprot.1.tar.gz", want: "sprot.1"},
+		{path: "sprot.edges.tsv", want: "sprot.edges"},
+	}
+	for _, tt := range tests {
+		s.Assert().Equal(tt.want, outputKey(tt.path))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// prolog matches an XML declaration or DOCTYPE, and the whitespace after it, at the start of a line.
+var prolog = regexp.MustCompile(`^\s*(<\?xml\s[^>]*\?>|<!DOCTYPE[^>\[]*(\[[^\]]*\])?\s*>)\s*`)
+
+// openProlog matches the start of an XML declaration or DOCTYPE that prolog could not match, as it
+// continues on the following lines.
+var openProlog = regexp.MustCompile(`^\s*(<\?xml\s|<!DOCTYPE)`)
+
+func mergeCommand(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	in := flags.String("in", "", "the folder of files to merge")
+	out := flags.String("out", "", "the path merged files are written to, numbered <out>.<n>.xml")
+	root := flags.String("root", "records", "the element records are wrapped in")
+	records := flags.Int("records", 1000, "number of records at which a new file is started (0 for no limit)")
+	rollover := flags.Int64("rollover", 0, "size in MB at which a new file is started (0 for no limit)")
+	skip := flags.String("skip", "", "regex for lines that should be skipped, after each document's XML declaration and DOCTYPE are stripped")
+	compress := flags.String("compress", "", "compress merged files (gzip, zlib, flate)")
+	level := flags.Int("compress-level", flate.DefaultCompression, "compression level, from -2 (huffman only) to 9 (best compression)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*in) == 0 || len(*out) == 0 {
+		flags.PrintDefaults()
+		return errors.New("values must be provided for -in and -out")
+	}
+	if _, ok := compressionExtensions[*compress]; *compress != "" && !ok {
+		return fmt.Errorf("unknown compression format '%s'", *compress)
+	}
+	m := &merger{
+		base:     *out,
+		root:     *root,
+		records:  *records,
+		limit:    *rollover * 1024 * 1024,
+		compress: *compress,
+		level:    *level,
+	}
+	if *skip != "" {
+		m.skip = regexp.MustCompile(*skip)
+	}
+	for _, path := range getFiles(strings.TrimRight(*in, "/")) {
+		if err := m.addFile(path); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := m.close(); err != nil {
+		return err
+	}
+	fmt.Printf("%d records merged into %d files\n", m.total, m.index)
+	return nil
+}
+
+// merger concatenates small documents, each holding a single record, into files of many records
+// wrapped in a root element. Each document's XML declaration and DOCTYPE are stripped from its start,
+// keeping anything that follows them on the same line, and lines matching skip, if set, are left out.
+// A new file, <base>.<n>.xml, is started once the current one holds the
+// configured number of records or uncompressed bytes.
+type merger struct {
+	base     string
+	root     string
+	skip     *regexp.Regexp
+	records  int
+	limit    int64
+	compress string
+	level    int
+	file     *outputFile
+	index    int
+	count    int
+	written  int64
+	total    int
+}
+
+// addFile appends the document at path, which may be gzipped, as a record.
+func (m *merger) addFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner, err := newScanner(file, strings.HasSuffix(path, ".gz"))
+	if err != nil {
+		return err
+	}
+	return m.add(scanner)
+}
+
+// add appends the document read by scanner as a record.
+func (m *merger) add(scanner *bufio.Scanner) error {
+	if m.file == nil || (m.records > 0 && m.count >= m.records) || (m.limit > 0 && m.written >= m.limit) {
+		if err := m.rollover(); err != nil {
+			return err
+		}
+	}
+	started := false
+	// pending holds a declaration or DOCTYPE, such as one with an internal subset, spanning lines.
+	pending := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !started {
+			line, pending = pending+line, ""
+			for {
+				match := prolog.FindStringIndex(line)
+				if match == nil {
+					break
+				}
+				line = line[match[1]:]
+			}
+			if openProlog.MatchString(line) {
+				pending = line + "\n"
+				continue
+			}
+		}
+		started = started || strings.TrimSpace(line) != ""
+		if line == "" || m.skip != nil && m.skip.MatchString(line) {
+			continue
+		}
+		if err := m.write(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// A prolog left open is not one, so it is kept as it was read.
+	if pending != "" {
+		if err := m.write(pending); err != nil {
+			return err
+		}
+	}
+	m.count++
+	m.total++
+	return nil
+}
+
+// rollover closes the current file, if any, and starts the next.
+func (m *merger) rollover() error {
+	if err := m.close(); err != nil {
+		return err
+	}
+	var err error
+	if m.file, err = createOutputFile(fmt.Sprintf("%s.%d.xml", m.base, m.index), m.compress, m.level); err != nil {
+		return err
+	}
+	m.index++
+	m.count, m.written = 0, 0
+	return m.write(xml.Header + "<" + m.root + ">\n")
+}
+
+func (m *merger) write(content string) error {
+	n, err := io.WriteString(m.file, content)
+	m.written += int64(n)
+	return err
+}
+
+// close completes the current file.
+func (m *merger) close() error {
+	if m.file == nil {
+		return nil
+	}
+	if err := m.write("</" + m.root + ">\n"); err != nil {
+		return err
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
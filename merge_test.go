@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MergeSuite struct {
+	tempDirSuite
+}
+
+func TestMergeSuite(t *testing.T) {
+	suite.Run(t, new(MergeSuite))
+}
+
+func (s *MergeSuite) read(name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	s.Require().NoError(err)
+	return string(content)
+}
+
+func (s *MergeSuite) TestAdd() {
+	documents := []string{
+		xml.Header + "<!DOCTYPE r>\n<r n=\"0\"/>",
+		"<?xml version=\"1.0\"?>\n\n<r n=\"1\">\ntext\n</r>\n",
+		"<r n=\"2\"/>",
+	}
+	want := []string{
+		xml.Header + "<records>\n<r n=\"0\"/>\n<r n=\"1\">\ntext\n</r>\n</records>\n",
+		xml.Header + "<records>\n<r n=\"2\"/>\n</records>\n",
+	}
+	tests := []struct {
+		records int
+		limit   int64
+	}{
+		{records: 2},
+		// The first file holds 60 bytes after the first record and 80 after the second.
+		{limit: 70},
+	}
+	for _, tt := range tests {
+		m := &merger{base: filepath.Join(s.dir, "batch"), root: "records", skip: regexp.MustCompile(defaultSkip), records: tt.records, limit: tt.limit}
+		for _, document := range documents {
+			s.Require().NoError(m.add(bufio.NewScanner(strings.NewReader(document))))
+		}
+		s.Require().NoError(m.close())
+		s.Assert().Equal(3, m.total)
+		s.Assert().Equal(2, m.index)
+		s.Assert().Equal(want[0], s.read("batch.0.xml"))
+		s.Assert().Equal(want[1], s.read("batch.1.xml"))
+	}
+}
+
+func (s *MergeSuite) TestAddSingleLine() {
+	documents := []string{
+		`<?xml version="1.0" encoding="UTF-8"?><doc>a</doc>`,
+		`<?xml version="1.0"?> <!DOCTYPE doc SYSTEM "doc.dtd"><doc n="b"><?xml-stylesheet href="s.xsl"?></doc>` + "\n",
+		"<?xml version=\"1.0\"?>\n<!DOCTYPE doc [<!ENTITY c \"c\">]><doc>&c;</doc>",
+	}
+	m := &merger{base: filepath.Join(s.dir, "batch"), root: "records"}
+	for _, document := range documents {
+		s.Require().NoError(m.add(bufio.NewScanner(strings.NewReader(document))))
+	}
+	s.Require().NoError(m.close())
+	s.Assert().Equal(3, m.total)
+	s.Assert().Equal(xml.Header+"<records>\n<doc>a</doc>\n<doc n=\"b\"><?xml-stylesheet href=\"s.xsl\"?></doc>\n<doc>&c;</doc>\n</records>\n", s.read("batch.0.xml"))
+}
+
+func (s *MergeSuite) TestAddMultilineDoctype() {
+	documents := []string{
+		"<?xml version=\"1.0\"\n    encoding=\"UTF-8\"?>\n<!DOCTYPE doc [\n  <!ENTITY c \"c\">\n  <!ENTITY d \"d\">\n]>\n<doc>&c;</doc>\n",
+		"<!DOCTYPE doc\n  SYSTEM \"doc.dtd\"><doc n=\"b\">\n</doc>",
+	}
+	m := &merger{base: filepath.Join(s.dir, "batch"), root: "records", skip: regexp.MustCompile(defaultSkip)}
+	for _, document := range documents {
+		s.Require().NoError(m.add(bufio.NewScanner(strings.NewReader(document))))
+	}
+	s.Require().NoError(m.close())
+	s.Assert().Equal(xml.Header+"<records>\n<doc>&c;</doc>\n<doc n=\"b\">\n</doc>\n</records>\n", s.read("batch.0.xml"))
+}
+
+func (s *MergeSuite) TestMergeCommand() {
+	in := filepath.Join(s.dir, "in")
+	s.Require().NoError(os.MkdirAll(in, 0755))
+	for i := 0; i < 5; i++ {
+		s.Require().NoError(ioutil.WriteFile(filepath.Join(in, fmt.Sprintf("%d.xml", i)), []byte(fmt.Sprintf("%s<r n=\"%d\"/>\n", xml.Header, i)), 0644))
+	}
+	s.Require().NoError(mergeCommand([]string{"-in", in, "-out", filepath.Join(s.dir, "batch"), "-root", "set", "-records", "3", "-compress", "gzip"}))
+
+	var merged []string
+	for _, name := range []string{"batch.0.xml.gz", "batch.1.xml.gz"} {
+		scanner, err := getScanner(filepath.Join(s.dir, name), true, nil)
+		s.Require().NoError(err)
+		for scanner.Scan() {
+			merged = append(merged, scanner.Text())
+		}
+	}
+	s.Assert().Equal([]string{
+		strings.TrimSpace(xml.Header), "<set>", `<r n="0"/>`, `<r n="1"/>`, `<r n="2"/>`, "</set>",
+		strings.TrimSpace(xml.Header), "<set>", `<r n="3"/>`, `<r n="4"/>`, "</set>",
+	}, merged)
+	s.Assert().Error(mergeCommand([]string{"-in", in, "-out", filepath.Join(s.dir, "batch"), "-compress", "lz4"}))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// rdfIdentifier matches the rdf:about or rdf:ID attribute of an opening tag.
+var rdfIdentifier = regexp.MustCompile(`\srdf:(?:about|ID)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// iriLocalName returns the local name of the IRI identifying the element opened by tag, the part
+// after the last '#', '/', ':' or ';' (which ends an entity reference such as &obo;), reduced to
+// characters that are safe in a file name. An empty string is returned if the element has no IRI.
+func iriLocalName(tag string) string {
+	match := rdfIdentifier.FindStringSubmatch(tag)
+	if match == nil {
+		return ""
+	}
+	iri := strings.TrimRight(match[1]+match[2], "#/")
+	name := iri[strings.LastIndexAny(iri, "#/:;")+1:]
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// isOntologyHeader reports whether an element is the owl:Ontology header of an ontology.
+func isOntologyHeader(name string) bool {
+	return name == "owl:Ontology"
+}
+
+// doctype declares entities in a DOCTYPE for the document element root, so the entity references
+// copied into the files written with -owl resolve without the DOCTYPE of the source.
+func doctype(root string, entities map[string]string) string {
+	names := make([]string, 0, len(entities))
+	for name := range entities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE " + root + " [\n")
+	for _, name := range names {
+		quote := `"`
+		if strings.Contains(entities[name], quote) {
+			quote = "'"
+		}
+		b.WriteString("    <!ENTITY " + name + " " + quote + entities[name] + quote + ">\n")
+	}
+	b.WriteString("]>\n")
+	return b.String()
+}
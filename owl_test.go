@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OWLSuite struct {
+	suite.Suite
+}
+
+func TestOWLSuite(t *testing.T) {
+	suite.Run(t, new(OWLSuite))
+}
+
+func (s *OWLSuite) TestIRILocalName() {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{tag: `<owl:Class rdf:about="http://purl.obolibrary.org/obo/GO_0000001">`, want: "GO_0000001"},
+		{tag: `<owl:Class rdf:about='http://example.org/onto#Cell'>`, want: "Cell"},
+		{tag: `<owl:Class rdf:about="&obo;GO_0000001">`, want: "GO_0000001"},
+		{tag: `<owl:ObjectProperty rdf:ID="part_of"/>`, want: "part_of"},
+		{tag: `<owl:NamedIndividual rdf:about="urn:example:tom cat">`, want: "tom_cat"},
+		{tag: `<owl:Ontology rdf:about="http://example.org/onto/">`, want: "onto"},
+		{tag: `<owl:Ontology rdf:about="">`, want: ""},
+		{tag: `<owl:Class>`, want: ""},
+	}
+	for _, tt := range tests {
+		s.Assert().Equal(tt.want, iriLocalName(tt.tag), tt.tag)
+	}
+}
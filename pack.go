@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	packIndexMagic     = "XSPIDX01"
+	packIndexHeader    = 24
+	packOrdinalEntry   = 16
+	packHashEntry      = 16
+	packIndexExtension = ".pack.idx"
+)
+
+// packEntry locates a single record within a pack segment.
+type packEntry struct {
+	hash    uint64
+	segment uint32
+	offset  uint64
+	length  uint32
+}
+
+// packWriter concatenates records into large segment files, deflating each record on its own, and
+// writes a sidecar index on close so any record can be fetched by key or ordinal in a constant
+// number of seeks.
+//
+// Each record in a segment is stored as a uvarint key length, the key, and the deflated content.
+// The key is the record path relative to the source's output directory without its extension,
+// e.g. uniprot/0/entry.1.
+//
+// The index starts with a header (magic, record count, hash slot count), followed by one
+// fixed-width entry per record ordinal (segment, offset, length), followed by an open addressing
+// hash table mapping the FNV-1a hash of each key to its ordinal.
+type packWriter struct {
+	base     string
+	limit    int64
+	level    int
+	segment  uint32
+	file     *os.File
+	buffered *bufio.Writer
+	offset   uint64
+	entries  []packEntry
+	buffer   bytes.Buffer
+	deflater *flate.Writer
+	listed   outputList
+}
+
+func newPackWriter(conf Config, source string) *packWriter {
+	return &packWriter{
+		base:  filepath.Join(conf.out, sourceName(source)),
+		limit: conf.rollover,
+		level: conf.compressLevel,
+	}
+}
+
+func (w *packWriter) write(actions []ioAction) ([]ioAction, error) {
+	for len(actions) > 0 && actions[0].ready {
+		if actions[0].actionType == writeFile {
+			key := relativePath(w.base, actions[0].path)
+			key = strings.TrimSuffix(key, filepath.Ext(key))
+			if err := w.writeRecord(key, actions[0].lines); err != nil {
+				return nil, err
+			}
+			if !actions[0].envelope && !actions[0].sidecar {
+				w.listed.count(1)
+			}
+		}
+		actions = actions[1:]
+	}
+	return actions, nil
+}
+
+func (w *packWriter) writeRecord(key string, lines []string) error {
+	if err := w.rollover(); err != nil {
+		return err
+	}
+	w.buffer.Reset()
+	var prefix [binary.MaxVarintLen64]byte
+	w.buffer.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(key)))])
+	w.buffer.WriteString(key)
+	w.deflater.Reset(&w.buffer)
+	for _, line := range lines {
+		if _, err := io.WriteString(w.deflater, line); err != nil {
+			return err
+		}
+	}
+	if err := w.deflater.Close(); err != nil {
+		return err
+	}
+	if _, err := w.buffered.Write(w.buffer.Bytes()); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, packEntry{
+		hash:    packHash(key),
+		segment: w.segment,
+		offset:  w.offset,
+		length:  uint32(w.buffer.Len()),
+	})
+	w.offset += uint64(w.buffer.Len())
+	return nil
+}
+
+// rollover opens the first segment, or the next one when the current segment has reached the size limit.
+func (w *packWriter) rollover() error {
+	if w.file != nil && (w.limit <= 0 || int64(w.offset) < w.limit) {
+		return nil
+	}
+	if w.file != nil {
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+		w.segment++
+	}
+	file, err := os.Create(packSegmentPath(w.base, w.segment))
+	if err != nil {
+		return err
+	}
+	stored := newCountingWriter(file)
+	w.listed.open(packSegmentPath(w.base, w.segment), stored)
+	if w.deflater == nil {
+		if w.deflater, err = flate.NewWriter(nil, w.level); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	w.file, w.buffered, w.offset = file, bufio.NewWriter(stored), 0
+	return nil
+}
+
+func (w *packWriter) closeSegment() error {
+	if err := w.buffered.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	err := w.file.Close()
+	w.listed.close()
+	w.file, w.buffered = nil, nil
+	return err
+}
+
+// close finishes the current segment and writes the index.
+func (w *packWriter) close() error {
+	if w.file != nil {
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+	}
+	slots := uint64(1)
+	for slots < uint64(len(w.entries))*2 {
+		slots <<= 1
+	}
+	table := make([]uint64, slots)
+	for ordinal, entry := range w.entries {
+		slot := entry.hash & (slots - 1)
+		for table[slot] != 0 {
+			slot = (slot + 1) & (slots - 1)
+		}
+		table[slot] = uint64(ordinal) + 1
+	}
+
+	file, err := os.Create(w.base + packIndexExtension)
+	if err != nil {
+		return err
+	}
+	stored := newCountingWriter(file)
+	w.listed.open(w.base+packIndexExtension, stored)
+	buffered := bufio.NewWriter(stored)
+	record := make([]byte, packIndexHeader)
+	copy(record, packIndexMagic)
+	binary.LittleEndian.PutUint64(record[8:], uint64(len(w.entries)))
+	binary.LittleEndian.PutUint64(record[16:], slots)
+	buffered.Write(record)
+	record = make([]byte, packOrdinalEntry)
+	for _, entry := range w.entries {
+		binary.LittleEndian.PutUint32(record[0:], entry.segment)
+		binary.LittleEndian.PutUint64(record[4:], entry.offset)
+		binary.LittleEndian.PutUint32(record[12:], entry.length)
+		buffered.Write(record)
+	}
+	record = make([]byte, packHashEntry)
+	for _, ordinal := range table {
+		var hash uint64
+		if ordinal > 0 {
+			hash = w.entries[ordinal-1].hash
+		}
+		binary.LittleEndian.PutUint64(record[0:], hash)
+		binary.LittleEndian.PutUint64(record[8:], ordinal)
+		buffered.Write(record)
+	}
+	if err := buffered.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	w.listed.close()
+	return err
+}
+
+func (w *packWriter) outputs() []output {
+	return w.listed.take()
+}
+
+func packSegmentPath(base string, segment uint32) string {
+	return fmt.Sprintf("%s.%d.pack", base, segment)
+}
+
+func packHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// packReader fetches individual records from segments written by packWriter.
+type packReader struct {
+	base  string
+	index *os.File
+	count uint64
+	slots uint64
+}
+
+// errPackRecordNotFound is returned when a key or ordinal is not present in a pack.
+var errPackRecordNotFound = errors.New("record not found")
+
+// openPack opens the pack written for a source, where base is the output folder joined with the source name.
+func openPack(base string) (*packReader, error) {
+	index, err := os.Open(base + packIndexExtension)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, packIndexHeader)
+	if _, err := io.ReadFull(index, header); err != nil {
+		index.Close()
+		return nil, err
+	}
+	if string(header[:8]) != packIndexMagic {
+		index.Close()
+		return nil, fmt.Errorf("'%s' is not a pack index", index.Name())
+	}
+	return &packReader{
+		base:  base,
+		index: index,
+		count: binary.LittleEndian.Uint64(header[8:]),
+		slots: binary.LittleEndian.Uint64(header[16:]),
+	}, nil
+}
+
+func (r *packReader) close() error {
+	return r.index.Close()
+}
+
+// recordByOrdinal returns the key and content of the nth record written to the pack.
+func (r *packReader) recordByOrdinal(n uint64) (string, []byte, error) {
+	if n >= r.count {
+		return "", nil, errPackRecordNotFound
+	}
+	entry := make([]byte, packOrdinalEntry)
+	if _, err := r.index.ReadAt(entry, int64(packIndexHeader+n*packOrdinalEntry)); err != nil {
+		return "", nil, err
+	}
+	segment, err := os.Open(packSegmentPath(r.base, binary.LittleEndian.Uint32(entry[0:])))
+	if err != nil {
+		return "", nil, err
+	}
+	defer segment.Close()
+	data := make([]byte, binary.LittleEndian.Uint32(entry[12:]))
+	if _, err := segment.ReadAt(data, int64(binary.LittleEndian.Uint64(entry[4:]))); err != nil {
+		return "", nil, err
+	}
+	keyLength, read := binary.Uvarint(data)
+	if read <= 0 || uint64(read)+keyLength > uint64(len(data)) {
+		return "", nil, fmt.Errorf("corrupt record %d in pack '%s'", n, r.base)
+	}
+	end := uint64(read) + keyLength
+	key := string(data[read:end])
+	content, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data[end:])))
+	return key, content, err
+}
+
+// recordByKey returns the content of the record with the given key.
+func (r *packReader) recordByKey(key string) ([]byte, error) {
+	hash := packHash(key)
+	slot := hash & (r.slots - 1)
+	entry := make([]byte, packHashEntry)
+	for i := uint64(0); i < r.slots; i++ {
+		offset := packIndexHeader + r.count*packOrdinalEntry + slot*packHashEntry
+		if _, err := r.index.ReadAt(entry, int64(offset)); err != nil {
+			return nil, err
+		}
+		ordinal := binary.LittleEndian.Uint64(entry[8:])
+		if ordinal == 0 {
+			break
+		}
+		if binary.LittleEndian.Uint64(entry[0:]) == hash {
+			found, content, err := r.recordByOrdinal(ordinal - 1)
+			if err != nil {
+				return nil, err
+			}
+			if found == key {
+				return content, nil
+			}
+		}
+		slot = (slot + 1) & (r.slots - 1)
+	}
+	return nil, errPackRecordNotFound
+}
+
+// getCommand prints a single record from a pack to stdout.
+func getCommand(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ExitOnError)
+	pack := flags.String("pack", "", "the pack to read, as the output folder joined with the source name (e.g. out/sprot)")
+	key := flags.String("key", "", "the key of the record to fetch (e.g. uniprot/0/entry.1)")
+	ordinal := flags.Int64("n", -1, "the ordinal of the record to fetch")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*pack) == 0 || (len(*key) == 0) == (*ordinal < 0) {
+		flags.PrintDefaults()
+		return errors.New("a value must be provided for -pack and exactly one of -key or -n")
+	}
+	r, err := openPack(strings.TrimRight(*pack, "/"))
+	if err != nil {
+		return err
+	}
+	defer r.close()
+	var content []byte
+	if len(*key) > 0 {
+		content, err = r.recordByKey(*key)
+	} else {
+		_, content, err = r.recordByOrdinal(uint64(*ordinal))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(content)
+	return err
+}
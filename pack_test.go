@@ -0,0 +1,99 @@
+package main
+
+import (
+	"compress/flate"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PackSuite struct {
+	tempDirSuite
+}
+
+func TestPackSuite(t *testing.T) {
+	suite.Run(t, new(PackSuite))
+}
+
+func (s *PackSuite) writePack(rollover int64, records int) {
+	w := newPackWriter(Config{out: s.dir, rollover: rollover, compressLevel: flate.DefaultCompression}, "in/sprot.xml")
+	actions := []ioAction{{actionType: newDirectory, path: s.dir + "/sprot/uniprot/0", ready: true}}
+	for i := 0; i < records; i++ {
+		actions = append(actions, ioAction{
+			actionType: writeFile,
+			path:       fmt.Sprintf("%s/sprot/uniprot/0/entry.%d.xml", s.dir, i),
+			lines:      []string{"<entry>", fmt.Sprintf("%d", i), "</entry>"},
+			ready:      true,
+		})
+	}
+	remaining, err := w.write(actions)
+	s.Require().NoError(err)
+	s.Require().Empty(remaining)
+	s.Require().NoError(w.close())
+}
+
+func (s *PackSuite) TestReadBack() {
+	tests := []struct {
+		rollover int64
+		segments int
+	}{
+		{rollover: 0, segments: 1},
+		{rollover: 64, segments: 50},
+	}
+	for _, tt := range tests {
+		s.writePack(tt.rollover, 100)
+		segments, err := filepath.Glob(filepath.Join(s.dir, "sprot.*.pack"))
+		s.Require().NoError(err)
+		s.Assert().Len(segments, tt.segments)
+
+		r, err := openPack(filepath.Join(s.dir, "sprot"))
+		s.Require().NoError(err)
+		for i := 0; i < 100; i++ {
+			content, err := r.recordByKey(fmt.Sprintf("uniprot/0/entry.%d", i))
+			s.Require().NoError(err)
+			s.Assert().Equal(fmt.Sprintf("<entry>%d</entry>", i), string(content))
+
+			key, content, err := r.recordByOrdinal(uint64(i))
+			s.Require().NoError(err)
+			s.Assert().Equal(fmt.Sprintf("uniprot/0/entry.%d", i), key)
+			s.Assert().Equal(fmt.Sprintf("<entry>%d</entry>", i), string(content))
+		}
+		_, err = r.recordByKey("uniprot/0/entry.100")
+		s.Assert().Equal(errPackRecordNotFound, err)
+		_, _, err = r.recordByOrdinal(100)
+		s.Assert().Equal(errPackRecordNotFound, err)
+		s.Require().NoError(r.close())
+
+		for _, segment := range segments {
+			s.Require().NoError(os.Remove(segment))
+		}
+	}
+}
+
+func (s *PackSuite) TestEmptyPack() {
+	s.writePack(0, 0)
+	r, err := openPack(filepath.Join(s.dir, "sprot"))
+	s.Require().NoError(err)
+	defer r.close()
+	_, err = r.recordByKey("uniprot/0/entry.0")
+	s.Assert().Equal(errPackRecordNotFound, err)
+}
+
+func (s *PackSuite) TestMissingOutputFolder() {
+	out := filepath.Join(s.dir, "missing", "out")
+	w, err := newWriter(Config{out: out, archive: "pack", compressLevel: flate.DefaultCompression}, "in/sprot.xml")
+	s.Require().NoError(err)
+	_, err = w.write([]ioAction{{actionType: writeFile, path: out + "/sprot/uniprot/0/entry.0.xml", lines: []string{"<entry/>"}, ready: true}})
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	r, err := openPack(filepath.Join(out, "sprot"))
+	s.Require().NoError(err)
+	defer r.close()
+	content, err := r.recordByKey("uniprot/0/entry.0")
+	s.Require().NoError(err)
+	s.Assert().Equal("<entry/>", string(content))
+}
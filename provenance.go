@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultProvenanceNamespace = "http://mdcatapult.io/xml-splitter/provenance"
+	provenanceExtension        = ".provenance.jsonl"
+)
+
+// run holds what is shared by every source file processed in a single run of the splitter.
+type run struct {
+	id string
+	// sequence is the number of records emitted so far across all source files.
+	sequence int64
+}
+
+// newRun starts a run with the given id, or one generated from the current time if it is empty.
+func newRun(id string) (*run, error) {
+	if id == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		id = time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	}
+	return &run{id: id}, nil
+}
+
+// next returns the next sequence number of the run, which is unique however many source files are
+// processed concurrently.
+func (r *run) next() int64 {
+	return atomic.AddInt64(&r.sequence, 1) - 1
+}
+
+// provenance records where a record came from. Offsets are of the uncompressed source in bytes,
+// with the end offset exclusive, and lines are numbered from 1.
+type provenance struct {
+	source      string
+	ordinal     int
+	sequence    int64
+	startOffset int64
+	endOffset   int64
+	startLine   int
+	endLine     int
+	run         string
+}
+
+// fields returns the provenance as named values, in the order they are written.
+func (p *provenance) fields() jsonObject {
+	return jsonObject{
+		{key: "source", value: p.source},
+		{key: "ordinal", value: p.ordinal},
+		{key: "sequence", value: p.sequence},
+		{key: "start-offset", value: p.startOffset},
+		{key: "end-offset", value: p.endOffset},
+		{key: "start-line", value: p.startLine},
+		{key: "end-line", value: p.endLine},
+		{key: "run", value: p.run},
+	}
+}
+
+// injectAttributes adds fields to the start tag of a record root as attributes in the given namespace.
+func injectAttributes(tag, namespace, prefix string, fields jsonObject) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ` xmlns:%s="`, prefix)
+	xml.EscapeText(&b, []byte(namespace))
+	b.WriteString(`"`)
+	for _, field := range fields {
+		fmt.Fprintf(&b, ` %s:%s="`, prefix, field.key)
+		xml.EscapeText(&b, []byte(fmt.Sprint(field.value)))
+		b.WriteString(`"`)
+	}
+	end := len(tag) - 1
+	if strings.HasSuffix(tag, "/>") {
+		end--
+	}
+	return strings.TrimRight(tag[:end], " \t") + b.String() + tag[end:]
+}
+
+// lineSegment maps a line as processed, which may join several lines of the source, back to the
+// source: the segment starting at index was read from the given offset and line number.
+type lineSegment struct {
+	index  int
+	offset int64
+	line   int
+}
+
+// trackLines makes scanner keep the byte offset and number of the last line it read in cache.
+func trackLines(scanner *bufio.Scanner, cache *processCache) {
+	var read int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			cache.lineOffset = read
+			cache.lineNumber++
+		}
+		read += int64(advance)
+		return advance, token, err
+	})
+}
+
+// strippedSpan is text removed from the processed line by -strip: length bytes that stood at index
+// of the line once stripped.
+type strippedSpan struct {
+	index  int
+	length int
+}
+
+// strip removes the matches of -strip from line, keeping where they were so positions in the
+// stripped line can still be mapped back to the source.
+func (s *XMLSplitter) strip(line string, cache *processCache) string {
+	cache.stripped = cache.stripped[:0]
+	var b strings.Builder
+	last := 0
+	for _, match := range s.conf.strip.FindAllStringIndex(line, -1) {
+		if match[0] == match[1] {
+			continue
+		}
+		b.WriteString(line[last:match[0]])
+		cache.stripped = append(cache.stripped, strippedSpan{index: b.Len(), length: match[1] - match[0]})
+		last = match[1]
+	}
+	if last == 0 {
+		return line
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// segment returns the segment of the line just read, starting at index within the processed line.
+func (p *processCache) segment(index int) lineSegment {
+	return lineSegment{index: index, offset: p.lineOffset, line: p.lineNumber}
+}
+
+// position returns the source offset and line number of the byte at index within the processed line.
+func (p *processCache) position(index int) (int64, int) {
+	if len(p.segments) == 0 {
+		return 0, 0
+	}
+	stripped := index
+	for _, s := range p.stripped {
+		if s.index > stripped {
+			break
+		}
+		index += s.length
+	}
+	segment := p.segments[0]
+	for _, s := range p.segments[1:] {
+		if s.index > index {
+			break
+		}
+		segment = s
+	}
+	return segment.offset + int64(index-segment.index), segment.line
+}
+
+// provenanceWriter writes the provenance of every record of a source file to a sidecar,
+// <out>/<source>.provenance.jsonl, keyed by the record key.
+type provenanceWriter struct {
+	conf   Config
+	source string
+	next   ioActionWriter
+	file   *outputFile
+	listed outputList
+}
+
+func (w *provenanceWriter) write(actions []ioAction) ([]ioAction, error) {
+	for _, action := range actions {
+		if !action.ready {
+			break
+		}
+		if action.provenance == nil {
+			continue
+		}
+		if w.file == nil {
+			var err error
+			path := filepath.Join(w.conf.out, sourceName(w.source)+provenanceExtension)
+			if w.file, err = createOutputFile(path, w.conf.compress, w.conf.compressLevel); err != nil {
+				return nil, err
+			}
+			w.listed.open(w.file.file.Name(), w.file.counter)
+		}
+		key := relativePath(w.conf.out, action.path)
+		line, err := marshalJSON(append(jsonObject{{key: "key", value: strings.TrimSuffix(key, filepath.Ext(key))}}, action.provenance.fields()...), false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.file.Write(append(line, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	return w.next.write(actions)
+}
+
+func (w *provenanceWriter) close() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.listed.close()
+	}
+	return closeWriter(w.next)
+}
+
+func (w *provenanceWriter) outputs() []output {
+	return append(listOutputs(w.next), w.listed.take()...)
+}
+
+// recordProvenance returns the provenance of the record being closed by the tag ending at index end.
+func (s *XMLSplitter) recordProvenance(cache *processCache, end int) *provenance {
+	p := &provenance{
+		source:      s.path,
+		ordinal:     cache.records,
+		startOffset: cache.recordOffset,
+		startLine:   cache.recordLineNumber,
+		run:         s.run.id,
+		sequence:    s.run.next(),
+	}
+	offset, line := cache.position(end - 1)
+	p.endOffset, p.endLine = offset+1, line
+	return p
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProvenanceSuite struct {
+	tempDirSuite
+}
+
+func TestProvenanceSuite(t *testing.T) {
+	suite.Run(t, new(ProvenanceSuite))
+}
+
+const provenanceSource = "<?xml version=\"1.0\"?>\n<set>\n  <item\n    id=\"1\">a</item>\n  <item id=\"2\"/>\n</set>\n"
+
+// split processes provenanceSource, removing matches of strip, and returns the records written.
+func (s *ProvenanceSuite) split(mode, strip string) []ioAction {
+	path := filepath.Join(s.dir, "set.xml")
+	s.Require().NoError(ioutil.WriteFile(path, []byte(provenanceSource), 0644))
+	conf := Config{
+		out:                 s.dir,
+		skip:                regexp.MustCompile(defaultSkip),
+		strip:               regexp.MustCompile(strip),
+		depth:               1,
+		buffer:              20,
+		provenance:          mode,
+		provenanceNamespace: "urn:p",
+		provenancePrefix:    "p",
+	}
+	r, err := newRun("run-1")
+	s.Require().NoError(err)
+	splitter := XMLSplitter{path: path, conf: conf, run: r}
+	writer := &mockWriter{}
+	writer.On("write", mock.Anything).Return([]ioAction{}, nil)
+	file, err := os.Open(path)
+	s.Require().NoError(err)
+	defer file.Close()
+	_, err = splitter.ProcessFile(bufio.NewScanner(file), writer)
+	s.Require().NoError(err)
+
+	var records []ioAction
+	for _, call := range writer.Calls {
+		for _, action := range call.Arguments.Get(0).([]ioAction) {
+			if action.actionType == writeFile && !action.envelope {
+				records = append(records, action)
+			}
+		}
+	}
+	return records
+}
+
+func (s *ProvenanceSuite) TestRecordProvenance() {
+	records := s.split("sidecar", "")
+	s.Require().Len(records, 2)
+	s.Assert().Equal(&provenance{source: filepath.Join(s.dir, "set.xml"), ordinal: 0, sequence: 0, startOffset: 30, endOffset: 55, startLine: 3, endLine: 4, run: "run-1"}, records[0].provenance)
+	s.Assert().Equal(&provenance{source: filepath.Join(s.dir, "set.xml"), ordinal: 1, sequence: 1, startOffset: 58, endOffset: 72, startLine: 5, endLine: 5, run: "run-1"}, records[1].provenance)
+	s.Assert().Equal(`<item id="1">a</item>`, strings.Replace(provenanceSource[30:55], "\n    ", " ", 1))
+	s.Assert().Equal(`<item id="2"/>`, provenanceSource[58:72])
+	s.Assert().Equal([]string{"<item    id=\"1\">", "a", "</item>"}, records[0].lines[1:])
+}
+
+func (s *ProvenanceSuite) TestStrippedOffsets() {
+	records := s.split("sidecar", `\s+id="\d"`)
+	s.Require().Len(records, 2)
+	s.Assert().Equal([]string{"<item>", "a", "</item>"}, records[0].lines[1:])
+	s.Assert().Equal([]string{"<item/>"}, records[1].lines[1:])
+	for i, want := range [][2]int64{{30, 55}, {58, 72}} {
+		s.Assert().Equal(want, [2]int64{records[i].provenance.startOffset, records[i].provenance.endOffset})
+	}
+}
+
+func (s *ProvenanceSuite) TestAttributes() {
+	records := s.split("attributes", "")
+	s.Require().Len(records, 2)
+	for i, rec := range records {
+		root, err := parseRecord(strings.Join(rec.lines, ""), nil)
+		s.Require().NoError(err)
+		s.Assert().Equal("urn:p", root.namespace("p"))
+		s.Assert().Equal(filepath.Join(s.dir, "set.xml"), root.value("@p:source"))
+		s.Assert().Equal([]string{string('0' + rune(i))}, root.values("@p:ordinal"))
+		s.Assert().Equal("run-1", root.value("@p:run"))
+	}
+	s.Assert().True(strings.HasSuffix(records[1].lines[1], `p:run="run-1"/>`))
+}
+
+func (s *ProvenanceSuite) TestSidecar() {
+	conf := Config{out: s.dir, provenance: "sidecar"}
+	w, err := newWriter(conf, "in/set.xml")
+	s.Require().NoError(err)
+	remaining, err := w.write([]ioAction{
+		{actionType: newDirectory, path: s.dir + "/set/set/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/set/set/0/item.0.xml", lines: []string{"<item/>"}, ready: true, provenance: &provenance{source: "in/set.xml", ordinal: 0, sequence: 4, startOffset: 1, endOffset: 8, startLine: 1, endLine: 1, run: "r"}},
+	})
+	s.Require().NoError(err)
+	s.Require().Empty(remaining)
+	s.Require().NoError(closeWriter(w))
+
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "set.provenance.jsonl"))
+	s.Require().NoError(err)
+	s.Assert().Equal(`{"key":"set/set/0/item.0","source":"in/set.xml","ordinal":0,"sequence":4,"start-offset":1,"end-offset":8,"start-line":1,"end-line":1,"run":"r"}`+"\n", string(content))
+}
+
+func (s *ProvenanceSuite) TestConcurrentSequence() {
+	r, err := newRun("")
+	s.Require().NoError(err)
+	s.Assert().NotEmpty(r.id)
+	seen := make(map[int64]bool)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				n := r.next()
+				mutex.Lock()
+				seen[n] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	s.Assert().Len(seen, 8000)
+	s.Assert().True(seen[0] && seen[7999])
+}
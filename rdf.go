@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	rdfNamespace   = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rdfType        = rdfNamespace + "type"
+	rdfFirst       = rdfNamespace + "first"
+	rdfRest        = rdfNamespace + "rest"
+	rdfNil         = rdfNamespace + "nil"
+	rdfXMLLiteral  = rdfNamespace + "XMLLiteral"
+	rdfDescription = rdfNamespace + "Description"
+	rdfRDF         = rdfNamespace + "RDF"
+	rdfLi          = rdfNamespace + "li"
+)
+
+// ntriplesFormat interprets each record as RDF/XML and writes the triples it describes as
+// N-Triples. Records are expected to be node elements, such as rdf:Description or owl:Class, as
+// produced by splitting an RDF/XML document below its rdf:RDF root; a record that is itself an
+// rdf:RDF element has each of its children interpreted.
+//
+// rdf:about, rdf:ID, rdf:nodeID and rdf:resource, typed node elements, property attributes, nested
+// node elements, rdf:li, rdf:parseType Literal, Resource and Collection, rdf:datatype and xml:lang
+// are supported. Relative IRIs are resolved against xml:base when one is in scope, or else the file
+// URI of the source document. Reification through rdf:ID on property elements is not.
+type ntriplesFormat struct{}
+
+func (f *ntriplesFormat) extension() string {
+	return ".nt"
+}
+
+func (f *ntriplesFormat) streamExtension() string {
+	return ".nt"
+}
+
+func (f *ntriplesFormat) render(rec record) ([]byte, error) {
+	line, err := f.renderLine(rec)
+	if err != nil || len(line) == 0 {
+		return line, err
+	}
+	return append(line, '\n'), nil
+}
+
+// renderLine returns the triples of a record, one per line, or nil if there are none.
+func (f *ntriplesFormat) renderLine(rec record) ([]byte, error) {
+	p := &rdfParser{prefix: blankNodePrefix(rec.key), base: sourceURI(rec.source)}
+	roots := []*node{rec.root}
+	if uri, _ := p.expand(rec.root, rec.root.name, false); uri == rdfRDF {
+		roots = rec.root.elements()
+	}
+	for _, root := range roots {
+		if _, err := p.nodeElement(root); err != nil {
+			return nil, fmt.Errorf("%s: %v", rec.key, err)
+		}
+	}
+	if len(p.triples) == 0 {
+		return nil, nil
+	}
+	return []byte(strings.Join(p.triples, "\n")), nil
+}
+
+// rdfParser accumulates the triples of a single record.
+type rdfParser struct {
+	prefix string
+	// base is the IRI relative IRIs are resolved against when no xml:base is in scope.
+	base    string
+	blanks  int
+	triples []string
+}
+
+// sourceURI is the file URI of the source document at path, or empty if there is none.
+func sourceURI(path string) string {
+	if path == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// blankNodePrefix derives a blank node label prefix from a record key, so labels generated for
+// different records of a stream do not collide.
+func blankNodePrefix(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, "r_"+key) + "_"
+}
+
+func (p *rdfParser) blank() string {
+	p.blanks++
+	return "_:" + p.prefix + strconv.Itoa(p.blanks)
+}
+
+func (p *rdfParser) emit(subject, predicate, object string) {
+	p.triples = append(p.triples, subject+" <"+escapeIRI(predicate)+"> "+object+" .")
+}
+
+// expand resolves a prefixed name to an IRI. Unprefixed attributes are in no namespace, so
+// cannot be expanded.
+func (p *rdfParser) expand(n *node, name xml.Name, isAttr bool) (string, error) {
+	if isAttr && name.Space == "" {
+		return "", fmt.Errorf("attribute %s has no namespace", name.Local)
+	}
+	uri := n.namespace(name.Space)
+	if uri == "" {
+		return "", fmt.Errorf("undeclared namespace prefix in %s", qualifiedName(name))
+	}
+	return uri + name.Local, nil
+}
+
+// resolve resolves a possibly relative IRI against the xml:base in scope at n, or the base of the
+// parser when there is none. N-Triples only allows absolute IRIs, so an IRI that cannot be made
+// absolute is an error.
+func (p *rdfParser) resolve(n *node, iri string) (string, error) {
+	ref, err := url.Parse(iri)
+	if err != nil || ref.IsAbs() {
+		return iri, nil
+	}
+	base, ok := n.inherited("xml:base")
+	if !ok {
+		base = p.base
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil || !baseURL.IsAbs() {
+		return "", fmt.Errorf("relative IRI '%s' has no absolute base to be resolved against", iri)
+	}
+	return baseURL.ResolveReference(ref).String(), nil
+}
+
+// resolveTerm resolves an IRI as resolve does and returns its term.
+func (p *rdfParser) resolveTerm(n *node, iri string) (string, error) {
+	resolved, err := p.resolve(n, iri)
+	if err != nil {
+		return "", err
+	}
+	return iriTerm(resolved), nil
+}
+
+// rdfAttr returns the value of an attribute in the RDF namespace.
+func (p *rdfParser) rdfAttr(n *node, local string) (string, bool) {
+	for _, attr := range n.attrs {
+		if attr.Name.Local == local && attr.Name.Space != "" && n.namespace(attr.Name.Space) == rdfNamespace {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// isSyntaxAttr reports whether attr is RDF/XML syntax rather than a property.
+func (p *rdfParser) isSyntaxAttr(n *node, attr xml.Attr) bool {
+	if isNamespaceDeclaration(attr) || attr.Name.Space == "xml" || attr.Name.Space == "" {
+		return true
+	}
+	if n.namespace(attr.Name.Space) != rdfNamespace {
+		return false
+	}
+	switch attr.Name.Local {
+	case "about", "ID", "nodeID", "resource", "parseType", "datatype":
+		return true
+	}
+	return false
+}
+
+// subject returns the term identifying a node element.
+func (p *rdfParser) subject(n *node) (string, error) {
+	if about, ok := p.rdfAttr(n, "about"); ok {
+		return p.resolveTerm(n, about)
+	}
+	if id, ok := p.rdfAttr(n, "ID"); ok {
+		return p.resolveTerm(n, "#"+id)
+	}
+	if nodeID, ok := p.rdfAttr(n, "nodeID"); ok {
+		return "_:" + nodeID, nil
+	}
+	return p.blank(), nil
+}
+
+// nodeElement emits the triples of a node element and returns its subject term.
+func (p *rdfParser) nodeElement(n *node) (string, error) {
+	subject, err := p.subject(n)
+	if err != nil {
+		return "", err
+	}
+	typeIRI, err := p.expand(n, n.name, false)
+	if err != nil {
+		return "", err
+	}
+	if typeIRI != rdfDescription {
+		p.emit(subject, rdfType, iriTerm(typeIRI))
+	}
+	if err := p.propertyAttributes(n, subject); err != nil {
+		return "", err
+	}
+	return subject, p.propertyElements(n, subject)
+}
+
+// propertyAttributes emits a triple for each property attribute of n.
+func (p *rdfParser) propertyAttributes(n *node, subject string) error {
+	for _, attr := range n.attrs {
+		if p.isSyntaxAttr(n, attr) {
+			continue
+		}
+		predicate, err := p.expand(n, attr.Name, true)
+		if err != nil {
+			return err
+		}
+		if predicate == rdfType {
+			object, err := p.resolveTerm(n, attr.Value)
+			if err != nil {
+				return err
+			}
+			p.emit(subject, predicate, object)
+		} else {
+			p.emit(subject, predicate, literalTerm(attr.Value, p.lang(n), ""))
+		}
+	}
+	return nil
+}
+
+// propertyElements emits the triples of each property element below n.
+func (p *rdfParser) propertyElements(n *node, subject string) error {
+	li := 0
+	for _, property := range n.elements() {
+		predicate, err := p.expand(property, property.name, false)
+		if err != nil {
+			return err
+		}
+		if predicate == rdfLi {
+			li++
+			predicate = rdfNamespace + "_" + strconv.Itoa(li)
+		}
+		if err := p.propertyElement(property, subject, predicate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *rdfParser) propertyElement(property *node, subject, predicate string) error {
+	if parseType, ok := p.rdfAttr(property, "parseType"); ok {
+		switch parseType {
+		case "Literal":
+			var b strings.Builder
+			for _, child := range property.children {
+				b.WriteString(child.xmlString())
+			}
+			p.emit(subject, predicate, literalTerm(b.String(), "", rdfXMLLiteral))
+		case "Collection":
+			object, err := p.collection(property.elements())
+			if err != nil {
+				return err
+			}
+			p.emit(subject, predicate, object)
+		default:
+			// Resource, and any unrecognised parse type, describes a blank node.
+			object := p.blank()
+			p.emit(subject, predicate, object)
+			return p.propertyElements(property, object)
+		}
+		return nil
+	}
+
+	if resource, ok := p.rdfAttr(property, "resource"); ok {
+		object, err := p.resolveTerm(property, resource)
+		if err != nil {
+			return err
+		}
+		p.emit(subject, predicate, object)
+		return p.propertyAttributes(property, object)
+	}
+	if nodeID, ok := p.rdfAttr(property, "nodeID"); ok {
+		p.emit(subject, predicate, "_:"+nodeID)
+		return p.propertyAttributes(property, "_:"+nodeID)
+	}
+	if children := property.elements(); len(children) > 0 {
+		object, err := p.nodeElement(children[0])
+		if err != nil {
+			return err
+		}
+		p.emit(subject, predicate, object)
+		return nil
+	}
+	for _, attr := range property.attrs {
+		if !p.isSyntaxAttr(property, attr) {
+			// An empty property element with property attributes describes a blank node.
+			object := p.blank()
+			p.emit(subject, predicate, object)
+			return p.propertyAttributes(property, object)
+		}
+	}
+	datatype, _ := p.rdfAttr(property, "datatype")
+	if datatype != "" {
+		var err error
+		if datatype, err = p.resolve(property, datatype); err != nil {
+			return err
+		}
+	}
+	p.emit(subject, predicate, literalTerm(property.innerText(), p.lang(property), datatype))
+	return nil
+}
+
+// collection emits an RDF list of the given node elements and returns its head.
+func (p *rdfParser) collection(items []*node) (string, error) {
+	head := iriTerm(rdfNil)
+	var previous string
+	for _, item := range items {
+		object, err := p.nodeElement(item)
+		if err != nil {
+			return "", err
+		}
+		cell := p.blank()
+		if previous == "" {
+			head = cell
+		} else {
+			p.emit(previous, rdfRest, cell)
+		}
+		p.emit(cell, rdfFirst, object)
+		previous = cell
+	}
+	if previous != "" {
+		p.emit(previous, rdfRest, iriTerm(rdfNil))
+	}
+	return head, nil
+}
+
+func (p *rdfParser) lang(n *node) string {
+	lang, _ := n.inherited("xml:lang")
+	return lang
+}
+
+func iriTerm(iri string) string {
+	return "<" + escapeIRI(iri) + ">"
+}
+
+// literalTerm formats a literal with an optional language tag or datatype.
+func literalTerm(value, lang, datatype string) string {
+	term := `"` + escapeLiteral(value) + `"`
+	if datatype != "" {
+		return term + "^^" + iriTerm(datatype)
+	}
+	if lang != "" {
+		return term + "@" + lang
+	}
+	return term
+}
+
+func escapeLiteral(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// escapeIRI escapes the characters that may not appear within an N-Triples IRI reference.
+func escapeIRI(iri string) string {
+	var b strings.Builder
+	for _, r := range iri {
+		if r <= 0x20 || strings.ContainsRune("<>\"{}|^`\\", r) {
+			fmt.Fprintf(&b, `\u%04X`, r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
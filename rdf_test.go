@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RDFSuite struct {
+	tempDirSuite
+}
+
+func TestRDFSuite(t *testing.T) {
+	suite.Run(t, new(RDFSuite))
+}
+
+var rdfContext = &scope{
+	namespaces: map[string]string{
+		"rdf":  rdfNamespace,
+		"rdfs": "http://www.w3.org/2000/01/rdf-schema#",
+		"owl":  "http://www.w3.org/2002/07/owl#",
+		"ex":   "http://example.org/",
+	},
+	attrs: []xml.Attr{{Name: xml.Name{Space: "xml", Local: "base"}, Value: "http://example.org/onto"}},
+}
+
+func (s *RDFSuite) render(content string) []string {
+	root, err := parseRecord(content, rdfContext)
+	s.Require().NoError(err)
+	line, err := (&ntriplesFormat{}).renderLine(record{key: "o/rdf:RDF/0/owl:Class.0", root: root})
+	s.Require().NoError(err)
+	return strings.Split(string(line), "\n")
+}
+
+func (s *RDFSuite) TestNodeElements() {
+	s.Assert().Equal([]string{
+		`<http://example.org/A> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/2002/07/owl#Class> .`,
+		`<http://example.org/A> <http://example.org/code> "a1"@en .`,
+		`<http://example.org/A> <http://www.w3.org/2000/01/rdf-schema#subClassOf> <http://example.org/B> .`,
+		`<http://example.org/A> <http://www.w3.org/2000/01/rdf-schema#label> "Ä \"label\"\nline"@en .`,
+		`<http://example.org/A> <http://www.w3.org/2000/01/rdf-schema#comment> "note"@fr .`,
+		`<http://example.org/A> <http://example.org/count> "3"^^<http://www.w3.org/2001/XMLSchema#int> .`,
+		`<http://example.org/A> <http://example.org/see> _:other .`,
+	}, s.render(`<owl:Class rdf:about="http://example.org/A" ex:code="a1" xml:lang="en">`+
+		`<rdfs:subClassOf rdf:resource="http://example.org/B"/>`+
+		`<rdfs:label>Ä "label"&#10;line</rdfs:label>`+
+		`<rdfs:comment xml:lang="fr">note</rdfs:comment>`+
+		`<ex:count rdf:datatype="http://www.w3.org/2001/XMLSchema#int">3</ex:count>`+
+		`<ex:see rdf:nodeID="other"/>`+
+		`</owl:Class>`))
+}
+
+func (s *RDFSuite) TestBlankNodes() {
+	s.Assert().Equal([]string{
+		`<http://example.org/onto#C> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://example.org/Thing> .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_1 <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/2002/07/owl#Restriction> .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_1 <http://www.w3.org/2002/07/owl#onProperty> <http://example.org/p> .`,
+		`<http://example.org/onto#C> <http://www.w3.org/2000/01/rdf-schema#subClassOf> _:r_o_rdf_RDF_0_owl_Class_0_1 .`,
+		`<http://example.org/onto#C> <http://example.org/r> _:r_o_rdf_RDF_0_owl_Class_0_2 .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_2 <http://www.w3.org/2000/01/rdf-schema#label> "nested" .`,
+		`<http://example.org/onto#C> <http://example.org/lit> "a<b x=\"1\">b</b>"^^<http://www.w3.org/1999/02/22-rdf-syntax-ns#XMLLiteral> .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_3 <http://www.w3.org/1999/02/22-rdf-syntax-ns#first> <http://example.org/X> .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_3 <http://www.w3.org/1999/02/22-rdf-syntax-ns#rest> _:r_o_rdf_RDF_0_owl_Class_0_4 .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_4 <http://www.w3.org/1999/02/22-rdf-syntax-ns#first> <http://example.org/Y> .`,
+		`_:r_o_rdf_RDF_0_owl_Class_0_4 <http://www.w3.org/1999/02/22-rdf-syntax-ns#rest> <http://www.w3.org/1999/02/22-rdf-syntax-ns#nil> .`,
+		`<http://example.org/onto#C> <http://www.w3.org/2002/07/owl#unionOf> _:r_o_rdf_RDF_0_owl_Class_0_3 .`,
+		`<http://example.org/onto#C> <http://www.w3.org/1999/02/22-rdf-syntax-ns#_1> "one" .`,
+		`<http://example.org/onto#C> <http://www.w3.org/1999/02/22-rdf-syntax-ns#_2> "two" .`,
+	}, s.render(`<ex:Thing rdf:ID="C">`+
+		`<rdfs:subClassOf><owl:Restriction><owl:onProperty rdf:resource="http://example.org/p"/></owl:Restriction></rdfs:subClassOf>`+
+		`<ex:r rdf:parseType="Resource"><rdfs:label>nested</rdfs:label></ex:r>`+
+		`<ex:lit rdf:parseType="Literal">a<b x="1">b</b></ex:lit>`+
+		`<owl:unionOf rdf:parseType="Collection"><rdf:Description rdf:about="http://example.org/X"/><rdf:Description rdf:about="http://example.org/Y"/></owl:unionOf>`+
+		`<rdf:li>one</rdf:li><rdf:li>two</rdf:li>`+
+		`</ex:Thing>`))
+}
+
+func (s *RDFSuite) TestUndeclaredPrefix() {
+	root, err := parseRecord(`<un:Thing rdf:about="x"/>`, rdfContext)
+	s.Require().NoError(err)
+	_, err = (&ntriplesFormat{}).renderLine(record{root: root})
+	s.Assert().Error(err)
+}
+
+func (s *RDFSuite) TestRelativeIRIs() {
+	context := &scope{namespaces: rdfContext.namespaces}
+	root, err := parseRecord(`<owl:Class rdf:ID="C"><rdfs:seeAlso rdf:resource="http://example.org/D"/></owl:Class>`, context)
+	s.Require().NoError(err)
+	_, err = (&ntriplesFormat{}).renderLine(record{key: "o/rdf:RDF/0/owl:Class.0", root: root})
+	s.Assert().Error(err)
+
+	source, err := filepath.Abs("onto.owl")
+	s.Require().NoError(err)
+	line, err := (&ntriplesFormat{}).renderLine(record{key: "o/rdf:RDF/0/owl:Class.0", source: source, root: root})
+	s.Require().NoError(err)
+	uri := "file://" + filepath.ToSlash(source) + "#C"
+	s.Assert().Equal([]string{
+		`<` + uri + `> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/2002/07/owl#Class> .`,
+		`<` + uri + `> <http://www.w3.org/2000/01/rdf-schema#seeAlso> <http://example.org/D> .`,
+	}, strings.Split(string(line), "\n"))
+}
+
+func (s *RDFSuite) TestDoctypeEntities() {
+	source := `<?xml version="1.0"?>
+<!DOCTYPE rdf:RDF [
+    <!ENTITY obo "http://purl.obolibrary.org/obo/" >
+]>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:owl="http://www.w3.org/2002/07/owl#" xml:base="&obo;go.owl">
+    <owl:Class rdf:about="&obo;GO_0000001"/>
+</rdf:RDF>
+`
+	conf := Config{out: s.dir, depth: 1, buffer: 20, format: "ntriples", stream: true, skip: regexp.MustCompile(defaultSkip), strip: regexp.MustCompile("")}
+	splitter := XMLSplitter{path: "go.owl", conf: conf}
+	w, err := newWriter(conf, splitter.path)
+	s.Require().NoError(err)
+	_, err = splitter.ProcessFile(bufio.NewScanner(strings.NewReader(source)), w)
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "go.nt"))
+	s.Require().NoError(err)
+	s.Assert().Equal("<http://purl.obolibrary.org/obo/GO_0000001> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://www.w3.org/2002/07/owl#Class> .\n", string(content))
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// danglingReferencesFile is the file, relative to the output folder, dangling references are reported in.
+const danglingReferencesFile = "references.dangling.tsv"
+
+// duplicateKeysFile is the file, relative to the output folder, keys declared by more than one record
+// are reported in.
+const duplicateKeysFile = "references.duplicates.tsv"
+
+// defaultReferenceKeys are the record-relative paths of the values references resolve to when
+// -ref-keys is not given: ID attributes, RDF subjects and PubMed and JATS article PMIDs.
+var defaultReferenceKeys = []string{
+	"**/@id",
+	"**/@xml:id",
+	"**/@rdf:about",
+	"MedlineCitation/PMID",
+	"front/article-meta/article-id[@pub-id-type=pmid]",
+}
+
+// defaultReferences are the rules added by "default" in -refs: IDREF attributes, RDF resources,
+// JATS cross references and the PMIDs cited in JATS and PubMed reference lists.
+var defaultReferences = []string{
+	"idref=**/@idref",
+	"xref=**/xref/@rid",
+	"resource=**/@rdf:resource",
+	"cites=**/ref-list/**/pub-id[@pub-id-type=pmid]",
+	"cites=**/ReferenceList/Reference/ArticleIdList/ArticleId[@IdType=pubmed]",
+}
+
+// referenceRule names the relation held by the values found at a record-relative path.
+type referenceRule struct {
+	relation string
+	path     string
+}
+
+// referenceEdge is a reference from a record, by its key, to the value of another record's key.
+type referenceEdge struct {
+	from     string
+	to       string
+	relation string
+	path     string
+}
+
+// duplicateKey is a key declared by a record after another record had already declared it.
+type duplicateKey struct {
+	key    string
+	record string
+	first  string
+}
+
+// referenceGraph collects the keys declared by, and references made between, the records of every
+// source file of a run so dangling references can be reported once all have been split. Keys are
+// global to the run, so a key declared by more than one record is reported as a duplicate, as the
+// references to it cannot tell those records apart.
+type referenceGraph struct {
+	keys       []string
+	rules      []referenceRule
+	mutex      sync.Mutex
+	declared   map[string]string
+	pending    []referenceEdge
+	duplicates []duplicateKey
+	listed     outputList
+}
+
+// newReferenceGraph returns the graph configured by -refs and -ref-keys, or nil if references are
+// not being extracted. Rules are of the form relation=path, or just path in which case the path is
+// also used as the relation, and "default" stands for the built-in rules.
+func newReferenceGraph(conf Config) *referenceGraph {
+	if len(conf.refs) == 0 {
+		return nil
+	}
+	g := &referenceGraph{keys: conf.refKeys, declared: make(map[string]string)}
+	if len(g.keys) == 0 {
+		g.keys = defaultReferenceKeys
+	}
+	for _, spec := range conf.refs {
+		specs := []string{spec}
+		if spec == "default" {
+			specs = defaultReferences
+		}
+		for _, spec := range specs {
+			rule := referenceRule{relation: spec, path: spec}
+			if i := strings.Index(spec, "="); i >= 0 {
+				rule = referenceRule{relation: spec[:i], path: spec[i+1:]}
+			}
+			g.rules = append(g.rules, rule)
+		}
+	}
+	return g
+}
+
+// extract returns the keys declared by a record and the references it makes.
+func (g *referenceGraph) extract(rec record) ([]string, []referenceEdge) {
+	var keys []string
+	for _, path := range g.keys {
+		matchValues(rec.root, path, func(value, _ string) {
+			keys = append(keys, value)
+		})
+	}
+	var edges []referenceEdge
+	for _, rule := range g.rules {
+		matchValues(rec.root, rule.path, func(value, path string) {
+			edges = append(edges, referenceEdge{from: rec.key, to: value, relation: rule.relation, path: path})
+		})
+	}
+	return keys, edges
+}
+
+// add records the keys and references of the record with the given key. References that do not yet
+// resolve are kept until the end of the run, as the record declaring their key may still be to come.
+func (g *referenceGraph) add(record string, keys []string, edges []referenceEdge) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		first, ok := g.declared[key]
+		if !ok {
+			g.declared[key] = record
+		} else if first != record && !seen[key] {
+			g.duplicates = append(g.duplicates, duplicateKey{key: key, record: record, first: first})
+		}
+		seen[key] = true
+	}
+	for _, edge := range edges {
+		if _, ok := g.declared[edge.to]; !ok {
+			g.pending = append(g.pending, edge)
+		}
+	}
+}
+
+// dangling returns the references to keys that no record declared, ordered by record.
+func (g *referenceGraph) dangling() []referenceEdge {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var dangling []referenceEdge
+	for _, edge := range g.pending {
+		if _, ok := g.declared[edge.to]; !ok {
+			dangling = append(dangling, edge)
+		}
+	}
+	sort.SliceStable(dangling, func(i, j int) bool {
+		return dangling[i].from < dangling[j].from
+	})
+	return dangling
+}
+
+// report writes the dangling references to <out>/references.dangling.tsv and returns their number.
+func (g *referenceGraph) report(out string) (int, error) {
+	dangling := g.dangling()
+	file, err := createOutputFile(filepath.Join(out, danglingReferencesFile), "", 0)
+	if err != nil {
+		return 0, err
+	}
+	g.listed.open(file.file.Name(), file.counter)
+	if err := writeReferenceEdges(file, dangling, true); err != nil {
+		file.Close()
+		return 0, err
+	}
+	err = file.Close()
+	g.listed.close()
+	return len(dangling), err
+}
+
+// reportDuplicates writes the keys declared by more than one record to
+// <out>/references.duplicates.tsv, ordered by record, and returns their number.
+func (g *referenceGraph) reportDuplicates(out string) (int, error) {
+	g.mutex.Lock()
+	duplicates := append([]duplicateKey(nil), g.duplicates...)
+	g.mutex.Unlock()
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].record < duplicates[j].record
+	})
+	file, err := createOutputFile(filepath.Join(out, duplicateKeysFile), "", 0)
+	if err != nil {
+		return 0, err
+	}
+	g.listed.open(file.file.Name(), file.counter)
+	tsv := &csvFormat{comma: '\t'}
+	rows := [][]string{{"key", "record", "first"}}
+	for _, d := range duplicates {
+		rows = append(rows, []string{d.key, d.record, d.first})
+	}
+	for _, values := range rows {
+		row, err := tsv.row(values)
+		if err == nil {
+			_, err = file.Write(append(row, '\n'))
+		}
+		if err != nil {
+			file.Close()
+			return 0, err
+		}
+	}
+	err = file.Close()
+	g.listed.close()
+	return len(duplicates), err
+}
+
+// outputs returns the reports written for the run as a whole.
+func (g *referenceGraph) outputs() []output {
+	return g.listed.take()
+}
+
+// matchValues calls emit with each value found at a record-relative path, and the path of the
+// element or attribute it was found in. Attribute values are split on whitespace, as IDREFS
+// attributes hold a list of references, and text values are trimmed.
+func matchValues(root *node, path string, emit func(value, path string)) {
+	elementPath, attr := splitAttributePath(path)
+	for _, match := range root.find(elementPath) {
+		if attr == "" {
+			if value := strings.TrimSpace(match.innerText()); value != "" {
+				emit(value, match.path())
+			}
+			continue
+		}
+		value, ok := match.attr(attr)
+		if !ok {
+			continue
+		}
+		location := "@" + attr
+		if p := match.path(); p != "" {
+			location = p + "/" + location
+		}
+		for _, field := range strings.Fields(value) {
+			emit(field, location)
+		}
+	}
+}
+
+// writeReferenceEdges writes a TSV row for each edge, preceded by the header row if header is set.
+func writeReferenceEdges(w io.Writer, edges []referenceEdge, header bool) error {
+	tsv := &csvFormat{comma: '\t'}
+	var rows [][]string
+	if header {
+		rows = append(rows, []string{"from", "to", "relation", "path"})
+	}
+	for _, edge := range edges {
+		rows = append(rows, []string{edge.from, edge.to, edge.relation, edge.path})
+	}
+	for _, values := range rows {
+		row, err := tsv.row(values)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(row, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referenceWriter extracts the references made by every record of a source file into an edge list,
+// <out>/<source>.edges.tsv, before passing the records on to the next writer.
+type referenceWriter struct {
+	graph  *referenceGraph
+	conf   Config
+	source string
+	next   ioActionWriter
+	file   *outputFile
+	scopes recordScopes
+	listed outputList
+}
+
+func newReferenceWriter(conf Config, source string, graph *referenceGraph, next ioActionWriter) *referenceWriter {
+	return &referenceWriter{graph: graph, conf: conf, source: source, next: next, scopes: make(recordScopes)}
+}
+
+func (w *referenceWriter) write(actions []ioAction) ([]ioAction, error) {
+	if w.file == nil {
+		var err error
+		if w.file, err = createOutputFile(filepath.Join(w.conf.out, sourceName(w.source)+".edges.tsv"), w.conf.compress, w.conf.compressLevel); err != nil {
+			return nil, err
+		}
+		w.listed.open(w.file.file.Name(), w.file.counter)
+		if err := writeReferenceEdges(w.file, nil, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, action := range actions {
+		if !action.ready {
+			break
+		}
+		if action.envelope {
+			if err := w.scopes.observe(action); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if action.actionType != writeFile {
+			continue
+		}
+		rec, err := newRecord(w.conf, w.source, action, w.scopes)
+		if err != nil {
+			return nil, err
+		}
+		keys, edges := w.graph.extract(rec)
+		w.graph.add(rec.key, keys, edges)
+		if err := writeReferenceEdges(w.file, edges, false); err != nil {
+			return nil, err
+		}
+	}
+	return w.next.write(actions)
+}
+
+func (w *referenceWriter) close() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.listed.close()
+	}
+	return closeWriter(w.next)
+}
+
+func (w *referenceWriter) outputs() []output {
+	return append(listOutputs(w.next), w.listed.take()...)
+}
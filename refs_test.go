@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RefsSuite struct {
+	tempDirSuite
+}
+
+func TestRefsSuite(t *testing.T) {
+	suite.Run(t, new(RefsSuite))
+}
+
+func (s *RefsSuite) TestExtract() {
+	tests := []struct {
+		name    string
+		content string
+		keys    []string
+		edges   []referenceEdge
+	}{
+		{
+			name:    "JATS article",
+			content: `<article><front><article-meta><article-id pub-id-type="pmid">1</article-id></article-meta></front><body><p>See <xref ref-type="fig" rid="f1 f2">1</xref></p><fig id="f1"/></body><back><ref-list><ref id="r1"><element-citation><pub-id pub-id-type="doi">10.1/x</pub-id><pub-id pub-id-type="pmid"> 2 </pub-id></element-citation></ref></ref-list></back></article>`,
+			keys:    []string{"f1", "r1", "1"},
+			edges: []referenceEdge{
+				{from: "a/0/article.0", to: "f1", relation: "xref", path: "body/p/xref/@rid"},
+				{from: "a/0/article.0", to: "f2", relation: "xref", path: "body/p/xref/@rid"},
+				{from: "a/0/article.0", to: "2", relation: "cites", path: "back/ref-list/ref/element-citation/pub-id"},
+			},
+		},
+		{
+			name:    "PubMed article",
+			content: `<PubmedArticle><MedlineCitation><PMID>3</PMID></MedlineCitation><PubmedData><ReferenceList><Reference><ArticleIdList><ArticleId IdType="pubmed">1</ArticleId></ArticleIdList></Reference></ReferenceList></PubmedData></PubmedArticle>`,
+			keys:    []string{"3"},
+			edges: []referenceEdge{
+				{from: "a/0/article.0", to: "1", relation: "cites", path: "PubmedData/ReferenceList/Reference/ArticleIdList/ArticleId"},
+			},
+		},
+		{
+			name:    "RDF",
+			content: `<owl:Class rdf:about="urn:a"><rdfs:subClassOf rdf:resource="urn:b"/></owl:Class>`,
+			keys:    []string{"urn:a"},
+			edges: []referenceEdge{
+				{from: "a/0/article.0", to: "urn:b", relation: "resource", path: "rdfs:subClassOf/@rdf:resource"},
+			},
+		},
+	}
+	g := newReferenceGraph(Config{refs: []string{"default"}})
+	for _, tt := range tests {
+		root, err := parseRecord(tt.content, nil)
+		s.Require().NoError(err)
+		keys, edges := g.extract(record{key: "a/0/article.0", root: root})
+		s.Assert().Equal(tt.keys, keys, tt.name)
+		s.Assert().Equal(tt.edges, edges, tt.name)
+	}
+}
+
+func (s *RefsSuite) TestCustomRules() {
+	g := newReferenceGraph(Config{refs: []string{"parent=Parent/@ref", "Link"}, refKeys: []string{"@code"}})
+	root, err := parseRecord(`<Item code="A"><Parent ref="B"/><Link>C</Link></Item>`, nil)
+	s.Require().NoError(err)
+	keys, edges := g.extract(record{key: "k", root: root})
+	s.Assert().Equal([]string{"A"}, keys)
+	s.Assert().Equal([]referenceEdge{
+		{from: "k", to: "B", relation: "parent", path: "Parent/@ref"},
+		{from: "k", to: "C", relation: "Link", path: "Link"},
+	}, edges)
+	s.Assert().Nil(newReferenceGraph(Config{}))
+}
+
+func (s *RefsSuite) TestDangling() {
+	conf := Config{out: s.dir, refs: []string{"xref=**/xref/@rid"}}
+	g := newReferenceGraph(conf)
+	sources := map[string][]string{
+		"in/a.xml": {`<doc id="a"><xref rid="b"/></doc>`},
+		"in/b.xml": {`<doc id="b"><xref rid="a"/><xref rid="missing"/></doc>`},
+	}
+	for _, source := range []string{"in/a.xml", "in/b.xml"} {
+		name := sourceName(source)
+		w := newReferenceWriter(conf, source, g, &writer{})
+		remaining, err := w.write([]ioAction{
+			{actionType: newDirectory, path: filepath.Join(s.dir, name, "docs/0"), ready: true},
+			{actionType: writeFile, path: filepath.Join(s.dir, name, "docs/0/root.xml"), lines: []string{"<docs/>"}, ready: true, envelope: true},
+			{actionType: writeFile, path: filepath.Join(s.dir, name, "docs/0/doc.0.xml"), lines: sources[source], ready: true},
+		})
+		s.Require().NoError(err)
+		s.Require().Empty(remaining)
+		s.Require().NoError(w.close())
+	}
+
+	edges, err := ioutil.ReadFile(filepath.Join(s.dir, "b.edges.tsv"))
+	s.Require().NoError(err)
+	s.Assert().Equal("from\tto\trelation\tpath\nb/docs/0/doc.0\ta\txref\txref/@rid\nb/docs/0/doc.0\tmissing\txref\txref/@rid\n", string(edges))
+
+	count, err := g.report(s.dir)
+	s.Require().NoError(err)
+	s.Assert().Equal(1, count)
+	dangling, err := ioutil.ReadFile(filepath.Join(s.dir, danglingReferencesFile))
+	s.Require().NoError(err)
+	s.Assert().Equal("from\tto\trelation\tpath\nb/docs/0/doc.0\tmissing\txref\txref/@rid\n", string(dangling))
+}
+
+func (s *RefsSuite) TestDuplicateKeys() {
+	g := newReferenceGraph(Config{refs: []string{"xref=**/xref/@rid"}})
+	for _, rec := range []struct {
+		key     string
+		content string
+	}{
+		{key: "a/articles/0/article.0", content: `<article id="a1"><fig id="f1"/><xref rid="f1"/></article>`},
+		{key: "a/articles/0/article.1", content: `<article id="a2"><fig id="f1" xml:id="f1"/><xref rid="f1"/></article>`},
+		{key: "b/articles/0/article.0", content: `<article id="a1"/>`},
+	} {
+		root, err := parseRecord(rec.content, nil)
+		s.Require().NoError(err)
+		keys, edges := g.extract(record{key: rec.key, root: root})
+		g.add(rec.key, keys, edges)
+	}
+
+	count, err := g.reportDuplicates(s.dir)
+	s.Require().NoError(err)
+	s.Assert().Equal(2, count)
+	duplicates, err := ioutil.ReadFile(filepath.Join(s.dir, duplicateKeysFile))
+	s.Require().NoError(err)
+	s.Assert().Equal("key\trecord\tfirst\nf1\ta/articles/0/article.1\ta/articles/0/article.0\na1\tb/articles/0/article.0\ta/articles/0/article.0\n", string(duplicates))
+}
+
+func (s *RefsSuite) TestMissingOutputFolder() {
+	out := filepath.Join(s.dir, "missing", "out")
+	conf := Config{out: out, refs: []string{"xref=**/xref/@rid"}}
+	w, err := newWriter(conf, "in/a.xml")
+	s.Require().NoError(err)
+	w = newReferenceWriter(conf, "in/a.xml", newReferenceGraph(conf), w)
+	_, err = w.write([]ioAction{
+		{actionType: newDirectory, path: filepath.Join(out, "a", "docs/0"), ready: true},
+		{actionType: writeFile, path: filepath.Join(out, "a", "docs/0/doc.0.xml"), lines: []string{`<doc id="a"><xref rid="b"/></doc>`}, ready: true},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	edges, err := ioutil.ReadFile(filepath.Join(out, "a.edges.tsv"))
+	s.Require().NoError(err)
+	s.Assert().Equal("from\tto\trelation\tpath\na/docs/0/doc.0\tb\txref\txref/@rid\n", string(edges))
+}
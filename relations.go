@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+const relationsExtension = ".relations.tsv"
+
+// relation links a record, or an element above the split depth, to the element enclosing it by
+// their keys.
+type relation struct {
+	parent  string
+	child   string
+	element string
+}
+
+// relationWriter lists the relation of every record and nested element of a source file to its
+// parent in <out>/<source>.relations.tsv, before passing the actions on to the next writer.
+type relationWriter struct {
+	conf   Config
+	source string
+	next   ioActionWriter
+	file   *outputFile
+	listed outputList
+}
+
+func (w *relationWriter) write(actions []ioAction) ([]ioAction, error) {
+	tsv := &csvFormat{comma: '\t'}
+	if w.file == nil {
+		var err error
+		if w.file, err = createOutputFile(filepath.Join(w.conf.out, sourceName(w.source)+relationsExtension), w.conf.compress, w.conf.compressLevel); err != nil {
+			return nil, err
+		}
+		w.listed.open(w.file.file.Name(), w.file.counter)
+		if err := w.writeRow(tsv, []string{"parent", "child", "element"}); err != nil {
+			return nil, err
+		}
+	}
+	for _, action := range actions {
+		if !action.ready {
+			break
+		}
+		if action.relation == nil {
+			continue
+		}
+		if err := w.writeRow(tsv, []string{action.relation.parent, action.relation.child, action.relation.element}); err != nil {
+			return nil, err
+		}
+	}
+	return w.next.write(actions)
+}
+
+func (w *relationWriter) writeRow(tsv *csvFormat, values []string) error {
+	row, err := tsv.row(values)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(append(row, '\n'))
+	return err
+}
+
+func (w *relationWriter) close() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.listed.close()
+	}
+	return closeWriter(w.next)
+}
+
+func (w *relationWriter) outputs() []output {
+	return append(listOutputs(w.next), w.listed.take()...)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RelationsSuite struct {
+	tempDirSuite
+}
+
+func TestRelationsSuite(t *testing.T) {
+	suite.Run(t, new(RelationsSuite))
+}
+
+func (s *RelationsSuite) TestRelationWriter() {
+	w, err := newWriter(Config{out: s.dir, parents: true}, "in/set.xml")
+	s.Require().NoError(err)
+	remaining, err := w.write([]ioAction{
+		{actionType: newDirectory, path: s.dir + "/set/set/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/set/set/0/root.xml", lines: []string{"<set/>"}, ready: true, envelope: true},
+		{actionType: newDirectory, path: s.dir + "/set/set/0/group/0", ready: true, relation: &relation{parent: "set/set/0", child: "g\t1", element: "group"}},
+		{actionType: writeFile, path: s.dir + "/set/set/0/group/0/item.0.xml", lines: []string{"<item/>"}, ready: true, relation: &relation{parent: "g\t1", child: "set/set/0/group/0/item.0", element: "item"}},
+		{actionType: writeFile, path: s.dir + "/set/set/0/group/0/item.1.xml", lines: []string{"<item>"}, relation: &relation{parent: "g\t1", child: "set/set/0/group/0/item.1", element: "item"}},
+	})
+	s.Require().NoError(err)
+	s.Require().Len(remaining, 1)
+	s.Require().NoError(closeWriter(w))
+
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "set.relations.tsv"))
+	s.Require().NoError(err)
+	s.Assert().Equal("parent\tchild\telement\n"+
+		"set/set/0\t\"g\t1\"\tgroup\n"+
+		"\"g\t1\"\tset/set/0/group/0/item.0\titem\n", string(content))
+	record, err := ioutil.ReadFile(filepath.Join(s.dir, "set/set/0/group/0/item.0.xml"))
+	s.Require().NoError(err)
+	s.Assert().Equal("<item/>", string(record))
+}
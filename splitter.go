@@ -43,18 +43,26 @@ type Tag struct {
 type XMLSplitter struct {
 	path string
 	conf Config
+	// run is used to record the provenance of records.
+	run *run
 }
 
-func (s *XMLSplitter) ProcessFile(scanner *bufio.Scanner, writer ioActionWriter) int {
+// ProcessFile splits the lines read by scanner into records, passing them to writer, and returns
+// the number of records and envelopes split, which are only written as a file each to loose files.
+func (s *XMLSplitter) ProcessFile(scanner *bufio.Scanner, writer ioActionWriter) (int, error) {
 	var err error
 
 	// cache is used to keep track of files/folders and xml depth so we don't overwrite files.
 	cache := &processCache{
-		currentDirectory: []string{s.conf.out, filepath.Base(strings.TrimSuffix(s.path, filepath.Ext(s.path)))},
+		currentDirectory: []string{s.conf.out, sourceName(s.path)},
 		directoryCounter: make(map[string]int),
 		fileCounter:      make(map[string]int),
 	}
 
+	if s.conf.provenance != "" {
+		trackLines(scanner, cache)
+	}
+
 	isMultilineTag := false
 
 	for scanner.Scan() {
@@ -64,6 +72,10 @@ func (s *XMLSplitter) ProcessFile(scanner *bufio.Scanner, writer ioActionWriter)
 			continue
 		}
 
+		if cache.depth == 0 {
+			collectEntities(line, cache)
+		}
+
 		skipMatches := s.conf.skip.FindStringSubmatch(line)
 		if len(skipMatches) > 0 {
 			continue
@@ -72,36 +84,63 @@ func (s *XMLSplitter) ProcessFile(scanner *bufio.Scanner, writer ioActionWriter)
 		if openTagStart.MatchString(line) {
 			isMultilineTag = true
 			cache.line = line
+			cache.segments = []lineSegment{cache.segment(0)}
 			continue
 		}
 
 		if openTagEnd.MatchString(line) {
+			if !isMultilineTag {
+				cache.segments = nil
+			}
+			cache.segments = append(cache.segments, cache.segment(len(cache.line)))
 			line = cache.line + line
 			cache.line = ""
 			isMultilineTag = false
-		}
-
-		if isMultilineTag {
+		} else if isMultilineTag {
+			cache.segments = append(cache.segments, cache.segment(len(cache.line)+1))
 			cache.line += " " + line
 			continue
+		} else {
+			cache.segments = []lineSegment{cache.segment(0)}
 		}
 
 		if s.conf.strip.String() != "" {
-			line = s.conf.strip.ReplaceAllString(line, "")
+			line = s.strip(line, cache)
 		}
 
 		s.processLine(line, cache)
 
 		if len(cache.ioActions) > s.conf.buffer {
-			cache.ioActions, err = writer.write(cache.ioActions)
-			handleError(err)
+			if cache.ioActions, err = writer.write(cache.ioActions); err != nil {
+				return 0, err
+			}
 		}
 	}
 
-	cache.ioActions, err = writer.write(cache.ioActions)
-	handleError(err)
+	if cache.ioActions, err = writer.write(cache.ioActions); err != nil {
+		return 0, err
+	}
+
+	return cache.totalFiles, nil
+}
 
-	return cache.totalFiles
+// entityDeclaration matches the declaration of a general entity with a literal value in a DOCTYPE.
+var entityDeclaration = regexp.MustCompile(`<!ENTITY\s+([^\s%]+)\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+
+// collectEntities records the general entities declared on a line of the prolog, so records
+// referring to them can be parsed once the DOCTYPE declaring them has been skipped.
+func collectEntities(line string, cache *processCache) {
+	for _, match := range entityDeclaration.FindAllStringSubmatch(line, -1) {
+		if cache.entities == nil {
+			cache.entities = make(map[string]string)
+		}
+		cache.entities[match[1]] = match[2] + match[3]
+	}
+}
+
+// sourceName is the name of the output directory created for the source file at path.
+func sourceName(path string) string {
+	return filepath.Base(strings.TrimSuffix(path, filepath.Ext(path)))
 }
 
 func (s *XMLSplitter) processLine(line string, cache *processCache) {
@@ -111,8 +150,14 @@ func (s *XMLSplitter) processLine(line string, cache *processCache) {
 	for i := 0; i < len(line); {
 		if tag, ok := lineStructure[i]; ok {
 
-			if cache.file && !whitespace.MatchString(cache.innerText) {
-				cache.appendLine(strings.TrimSpace(cache.innerText))
+			if s.collectsText(cache) && cache.innerText != "" {
+				if text, ok := s.textRun(cache.innerText); ok {
+					if cache.file {
+						cache.appendLine(text)
+					} else {
+						cache.appendEnvelope(text)
+					}
+				}
 				cache.innerText = ""
 			}
 
@@ -120,10 +165,10 @@ func (s *XMLSplitter) processLine(line string, cache *processCache) {
 			case Opening:
 
 				if cache.depth < s.conf.depth {
-					cache.newDirectory(tag.Name)
-					cache.appendFile("root", tag.Full[:len(tag.Full)-1]+"/>")
+					s.enterElement(tag, cache)
+					cache.ancestors = append(cache.ancestors, tag)
 				} else if !cache.file {
-					cache.openFile(tag.Name)
+					s.openRecord(tag, cache)
 					cache.appendLine(tag.Full)
 				} else {
 					cache.appendLine(tag.Full)
@@ -136,22 +181,30 @@ func (s *XMLSplitter) processLine(line string, cache *processCache) {
 					cache.appendLine(tag.Full)
 				} else if cache.depth == s.conf.depth+1 {
 					cache.appendLine(tag.Full)
-					cache.closeFile()
+					s.closeRecord(tag, cache)
 				} else if tag.Name == cache.currentDirectory[len(cache.currentDirectory)-2] && cache.depth <= s.conf.depth {
+					if s.fullEnvelopes() {
+						cache.exitEnvelope(tag.Full)
+					}
 					cache.exitDirectory()
+					cache.ancestors = cache.ancestors[:len(cache.ancestors)-1]
+					cache.parents = cache.parents[:len(cache.parents)-1]
 				}
 				cache.depth--
 
 			case Empty:
 
 				if cache.depth < s.conf.depth {
-					cache.newDirectory(tag.Name)
-					cache.appendFile("root", tag.Full)
+					s.enterElement(tag, cache)
+					if s.fullEnvelopes() {
+						cache.exitEnvelope("")
+					}
 					cache.exitDirectory()
+					cache.parents = cache.parents[:len(cache.parents)-1]
 				} else if !cache.file {
-					cache.openFile(tag.Name)
+					s.openRecord(tag, cache)
 					cache.appendLine(tag.Full)
-					cache.closeFile()
+					s.closeRecord(tag, cache)
 				} else {
 					cache.appendLine(tag.Full)
 				}
@@ -160,23 +213,158 @@ func (s *XMLSplitter) processLine(line string, cache *processCache) {
 
 		} else {
 
-			if cache.file {
-				cache.innerText += string(line[i])
+			if s.collectsText(cache) {
+				cache.innerText += line[i : i+1]
 			}
 			i++
-			if cache.file && i == len(line) {
-				cache.innerText += "\n"
+
+		}
+	}
+	if s.collectsText(cache) {
+		cache.innerText += "\n"
+	}
+}
+
+// textRun returns a run of text between two tags as it is written, and whether it is written at all.
+// Runs are trimmed, and runs of whitespace alone, the indentation between elements, are dropped.
+// The text formats keep text as it is, as the whitespace separates the words of inline elements.
+func (s *XMLSplitter) textRun(text string) (string, bool) {
+	if s.conf.format == "text" || s.conf.format == "standoff" {
+		return text, true
+	}
+	return strings.TrimSpace(text), !whitespace.MatchString(text)
+}
+
+// fullEnvelopes reports whether the content of elements above the split depth is written to their
+// envelopes, rather than just a stub of their start tag.
+func (s *XMLSplitter) fullEnvelopes() bool {
+	return s.conf.envelope == "full" || s.conf.envelope == "placeholders"
+}
+
+// collectsText reports whether text at the current position is kept, either in a record or an envelope.
+func (s *XMLSplitter) collectsText(cache *processCache) bool {
+	return cache.file || s.fullEnvelopes() && len(cache.envelopes) > 0
+}
+
+// enterElement creates the directory of an element above the split depth and starts its envelope.
+func (s *XMLSplitter) enterElement(tag Tag, cache *processCache) {
+	cache.newDirectory(tag.Name)
+	key := s.elementKey(tag, cache)
+	if s.conf.parents && len(cache.parents) > 0 {
+		cache.ioActions[len(cache.ioActions)-1].relation = &relation{parent: cache.parents[len(cache.parents)-1], child: key, element: tag.Name}
+	}
+	cache.parents = append(cache.parents, key)
+	if !s.fullEnvelopes() {
+		cache.appendFile("root", emptyElement(tag.Full))
+		cache.ioActions[len(cache.ioActions)-1].element = tag.Name
+		if len(cache.ancestors) == 0 {
+			cache.ioActions[len(cache.ioActions)-1].entities = cache.entities
+		}
+		return
+	}
+	if s.conf.envelope == "placeholders" {
+		dir := cache.currentDirectory[len(cache.currentDirectory)-2:]
+		cache.appendEnvelope(placeholder(strings.Join(dir, "/") + "/root.xml"))
+	}
+	cache.enterEnvelope(tag.Full)
+	cache.envelopes[len(cache.envelopes)-1].element = tag.Name
+	if len(cache.ancestors) == 0 {
+		cache.ioActions[len(cache.ioActions)-1].entities = cache.entities
+		cache.envelopes[len(cache.envelopes)-1].entities = cache.entities
+	}
+}
+
+// elementKey is the key records inside the element above the split depth opened by tag are given
+// as their parent: the value of the -parent-key attribute in its start tag, or else its output directory.
+func (s *XMLSplitter) elementKey(tag Tag, cache *processCache) string {
+	if s.conf.parentKey != "" {
+		if root, err := parseRecord(emptyElement(tag.Full), &scope{entities: cache.entities}); err == nil {
+			if key := root.value(s.conf.parentKey); key != "" {
+				return key
 			}
+		}
+	}
+	return relativePath(s.conf.out, strings.Join(cache.currentDirectory, "/"))
+}
+
+// placeholder is the element standing in an envelope for the file at href, relative to the envelope.
+// Paths starting with a prefixed element name are made explicitly relative, so the prefix is not
+// taken for a URI scheme.
+func placeholder(href string) string {
+	if strings.Contains(strings.SplitN(href, "/", 2)[0], ":") {
+		href = "./" + href
+	}
+	return `<xi:include xmlns:xi="http://www.w3.org/2001/XInclude" href="` + href + `"/>`
+}
 
+// openRecord starts the file for the record opened by tag. In OWL mode records are named after
+// their IRI and begin with the entities declared by the source, and with -wrap or in OWL mode
+// records begin inside copies of the elements enclosing them.
+func (s *XMLSplitter) openRecord(tag Tag, cache *processCache) {
+	cache.record = tag
+	if name := iriLocalName(tag.Full); s.conf.owl && name != "" {
+		cache.openNamedFile(name)
+	} else {
+		cache.openFile(tag.Name)
+	}
+	if s.conf.envelope == "placeholders" {
+		cache.appendEnvelope(placeholder(filepath.Base(cache.ioActions[len(cache.ioActions)-1].path)))
+	}
+	if s.conf.owl && len(cache.entities) > 0 && len(cache.ancestors) > 0 {
+		cache.appendLine(doctype(cache.ancestors[0].Name, cache.entities))
+	}
+	if s.conf.owl || s.conf.wrap {
+		for _, ancestor := range cache.ancestors {
+			cache.appendLine(ancestor.Full)
+		}
+	}
+	if s.conf.owlHeader && !isOntologyHeader(tag.Name) {
+		for _, line := range cache.ontology {
+			cache.appendLine(line)
+		}
+	}
+	cache.ioActions[len(cache.ioActions)-1].element = tag.Name
+	cache.recordLine = len(cache.ioActions[len(cache.ioActions)-1].lines)
+	cache.recordOffset, cache.recordLineNumber = cache.position(tag.Start)
+}
+
+// closeRecord completes the file of the current record, ended by tag, closing any copies of its
+// ancestors and recording its parent and provenance.
+func (s *XMLSplitter) closeRecord(tag Tag, cache *processCache) {
+	action := &cache.ioActions[len(cache.ioActions)-1]
+	var attrs jsonObject
+	if s.conf.parents && len(cache.parents) > 0 {
+		key := relativePath(s.conf.out, action.path)
+		action.relation = &relation{parent: cache.parents[len(cache.parents)-1], child: strings.TrimSuffix(key, filepath.Ext(key)), element: cache.record.Name}
+		attrs = append(attrs, jsonField{key: "parent", value: action.relation.parent})
+	}
+	if s.conf.provenance != "" {
+		action.provenance = s.recordProvenance(cache, tag.End)
+		if s.conf.provenance == "attributes" {
+			attrs = append(attrs, action.provenance.fields()...)
+		}
+	}
+	if len(attrs) > 0 {
+		action.lines[cache.recordLine] = injectAttributes(action.lines[cache.recordLine], s.conf.provenanceNamespace, s.conf.provenancePrefix, attrs)
+	}
+	cache.records++
+	if s.conf.owl && isOntologyHeader(cache.record.Name) && cache.ontology == nil {
+		lines := cache.ioActions[len(cache.ioActions)-1].lines
+		cache.ontology = append([]string(nil), lines[cache.recordLine:]...)
+	}
+	if s.conf.owl || s.conf.wrap {
+		for i := len(cache.ancestors) - 1; i >= 0; i-- {
+			cache.appendLine("</" + cache.ancestors[i].Name + ">")
 		}
 	}
+	cache.closeFile()
 }
 
 func (s *XMLSplitter) getLineStructure(line string) map[int]Tag {
 	lineStructure := make(map[int]Tag)
 
-	args := []struct{
-		regex *regexp.Regexp
+	args := []struct {
+		regex   *regexp.Regexp
 		tagType TagType
 	}{
 		{openingTag, Opening},
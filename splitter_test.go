@@ -3,8 +3,10 @@ package main
 import (
 	"bufio"
 	"encoding/xml"
+	"io"
 	"github.com/stretchr/testify/mock"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -96,6 +98,8 @@ func (s *SplitterSuite) TestProcessFile() {
 			path:       "out/sprot/uniprot/0/root.xml",
 			lines:      []string{xml.Header + `<uniprot xmlns="http://uniprot.org/uniprot"  xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"  xsi:schemaLocation="http://uniprot.org/uniprot http://www.uniprot.org/docs/uniprot.xsd"/>`},
 			ready:      true,
+			envelope:   true,
+			element:    "uniprot",
 		},
 		{
 			actionType: writeFile,
@@ -118,7 +122,8 @@ func (s *SplitterSuite) TestProcessFile() {
 				"</protein>",
 				"</entry>",
 			},
-			ready: true,
+			ready:   true,
+			element: "entry",
 		},
 		{
 			actionType: writeFile,
@@ -141,12 +146,227 @@ func (s *SplitterSuite) TestProcessFile() {
 				"</protein>",
 				"</entry>",
 			},
-			ready: true,
+			ready:   true,
+			element: "entry",
 		},
 	}).Return([]ioAction{}, nil)
-	totalFiles := splitter.ProcessFile(bufio.NewScanner(reader), writer)
+	totalFiles, err := splitter.ProcessFile(bufio.NewScanner(reader), writer)
+	s.Require().NoError(err)
 
 	s.Assert().Equal(3, totalFiles)
 	reader.AssertNumberOfCalls(s.T(), "Read", 2)
 	writer.AssertNumberOfCalls(s.T(), "write", 1)
 }
+
+func (s *SplitterSuite) TestProcessLineMultibyteText() {
+	splitter := XMLSplitter{conf: Config{depth: 1}}
+	cache := &processCache{
+		currentDirectory: []string{"out", "articles"},
+		directoryCounter: make(map[string]int),
+		fileCounter:      make(map[string]int),
+	}
+	splitter.processLine("<articles><title>Héllo wörld → ✓</title></articles>", cache)
+
+	s.Assert().Equal([]string{xml.Header, "<title>", "Héllo wörld → ✓", "</title>"}, cache.ioActions[2].lines)
+}
+
+func (s *SplitterSuite) TestProcessLineOWL() {
+	rdf := `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:owl="http://www.w3.org/2002/07/owl#">`
+	doctype := "<!DOCTYPE rdf:RDF [\n    <!ENTITY obo \"http://purl.obolibrary.org/obo/\">\n]>\n"
+	header := []string{`<owl:Ontology rdf:about="&obo;go.owl">`, `<owl:imports rdf:resource="&obo;bfo.owl"/>`, "</owl:Ontology>"}
+	tests := []struct {
+		owlHeader bool
+		want      []ioAction
+	}{
+		{
+			want: []ioAction{
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/go.owl.xml", lines: append(append([]string{xml.Header, doctype, rdf}, header...), "</rdf:RDF>"), ready: true, element: "owl:Ontology"},
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/GO_0000001.xml", lines: []string{xml.Header, doctype, rdf, `<owl:Class rdf:about="&obo;GO_0000001">`, "</owl:Class>", "</rdf:RDF>"}, ready: true, element: "owl:Class"},
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/part_of.xml", lines: []string{xml.Header, doctype, rdf, `<owl:ObjectProperty rdf:ID="part_of"/>`, "</rdf:RDF>"}, ready: true, element: "owl:ObjectProperty"},
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/owl:AllDisjointClasses.0.xml", lines: []string{xml.Header, doctype, rdf, "<owl:AllDisjointClasses/>", "</rdf:RDF>"}, ready: true, element: "owl:AllDisjointClasses"},
+			},
+		},
+		{
+			owlHeader: true,
+			want: []ioAction{
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/go.owl.xml", lines: append(append([]string{xml.Header, doctype, rdf}, header...), "</rdf:RDF>"), ready: true, element: "owl:Ontology"},
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/GO_0000001.xml", lines: append(append([]string{xml.Header, doctype, rdf}, header...), `<owl:Class rdf:about="&obo;GO_0000001">`, "</owl:Class>", "</rdf:RDF>"), ready: true, element: "owl:Class"},
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/part_of.xml", lines: append(append([]string{xml.Header, doctype, rdf}, header...), `<owl:ObjectProperty rdf:ID="part_of"/>`, "</rdf:RDF>"), ready: true, element: "owl:ObjectProperty"},
+				{actionType: writeFile, path: "out/go/rdf:RDF/0/owl:AllDisjointClasses.0.xml", lines: append(append([]string{xml.Header, doctype, rdf}, header...), "<owl:AllDisjointClasses/>", "</rdf:RDF>"), ready: true, element: "owl:AllDisjointClasses"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		splitter := XMLSplitter{conf: Config{depth: 1, owl: true, owlHeader: tt.owlHeader}}
+		cache := &processCache{
+			currentDirectory: []string{"out", "go"},
+			directoryCounter: make(map[string]int),
+			fileCounter:      make(map[string]int),
+		}
+		collectEntities(`<!DOCTYPE rdf:RDF [ <!ENTITY obo "http://purl.obolibrary.org/obo/" > ]>`, cache)
+		splitter.processLine(rdf, cache)
+		splitter.processLine(strings.Join(header, ""), cache)
+		splitter.processLine(`<owl:Class rdf:about="&obo;GO_0000001"></owl:Class>`, cache)
+		splitter.processLine(`<owl:ObjectProperty rdf:ID="part_of"/>`, cache)
+		splitter.processLine("<owl:AllDisjointClasses/>", cache)
+		splitter.processLine("</rdf:RDF>", cache)
+
+		s.Assert().Equal(tt.want, cache.ioActions[2:])
+		s.Assert().Empty(cache.ancestors)
+		for _, action := range cache.ioActions[2:] {
+			s.assertStandalone(strings.Join(action.lines, ""))
+		}
+	}
+}
+
+// assertStandalone parses a file strictly, resolving only the entities its own DOCTYPE declares.
+func (s *SplitterSuite) assertStandalone(content string) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Entity = make(map[string]string)
+	for _, match := range entityDeclaration.FindAllStringSubmatch(content, -1) {
+		decoder.Entity[match[1]] = match[2] + match[3]
+	}
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		s.Require().NoError(err, content)
+	}
+}
+
+func (s *SplitterSuite) TestProcessLineWrap() {
+	splitter := XMLSplitter{conf: Config{depth: 2, wrap: true}}
+	cache := &processCache{
+		currentDirectory: []string{"out", "data"},
+		directoryCounter: make(map[string]int),
+		fileCounter:      make(map[string]int),
+	}
+	splitter.processLine(`<collection xmlns="urn:c" version="2">`, cache)
+	splitter.processLine(`<group id="g1"><item>a</item><item/></group>`, cache)
+	splitter.processLine(`<group id="g2"><item>b</item></group>`, cache)
+	splitter.processLine(`</collection>`, cache)
+
+	var files [][]string
+	for _, action := range cache.ioActions {
+		if action.actionType == writeFile && !action.envelope {
+			files = append(files, action.lines)
+		}
+	}
+	s.Assert().Equal([][]string{
+		{xml.Header, `<collection xmlns="urn:c" version="2">`, `<group id="g1">`, "<item>", "a", "</item>", "</group>", "</collection>"},
+		{xml.Header, `<collection xmlns="urn:c" version="2">`, `<group id="g1">`, "<item/>", "</group>", "</collection>"},
+		{xml.Header, `<collection xmlns="urn:c" version="2">`, `<group id="g2">`, "<item>", "b", "</item>", "</group>", "</collection>"},
+	}, files)
+	s.Assert().Empty(cache.ancestors)
+}
+
+func (s *SplitterSuite) TestProcessLineEnvelope() {
+	xi := `xmlns:xi="http://www.w3.org/2001/XInclude"`
+	tests := []struct {
+		envelope string
+		want     []ioAction
+	}{
+		{
+			envelope: "full",
+			want: []ioAction{
+				{actionType: writeFile, path: "out/data/set/0/meta/0/root.xml", lines: []string{xml.Header, "<meta/>"}, ready: true, envelope: true, element: "meta"},
+				{actionType: writeFile, path: "out/data/set/0/group/0/root.xml", lines: []string{xml.Header, `<group id="g">`, "<!-- note -->", "</group>"}, ready: true, envelope: true, element: "group"},
+				{actionType: writeFile, path: "out/data/set/0/root.xml", lines: []string{xml.Header, `<set version="2">`, "Intro", "</set>"}, ready: true, envelope: true, element: "set"},
+			},
+		},
+		{
+			envelope: "placeholders",
+			want: []ioAction{
+				{actionType: writeFile, path: "out/data/set/0/meta/0/root.xml", lines: []string{xml.Header, "<meta/>"}, ready: true, envelope: true, element: "meta"},
+				{actionType: writeFile, path: "out/data/set/0/group/0/root.xml", lines: []string{
+					xml.Header,
+					`<group id="g">`,
+					`<xi:include ` + xi + ` href="item.0.xml"/>`,
+					"<!-- note -->",
+					`<xi:include ` + xi + ` href="item.1.xml"/>`,
+					"</group>",
+				}, ready: true, envelope: true, element: "group"},
+				{actionType: writeFile, path: "out/data/set/0/root.xml", lines: []string{
+					xml.Header,
+					`<set version="2">`,
+					"Intro",
+					`<xi:include ` + xi + ` href="meta/0/root.xml"/>`,
+					`<xi:include ` + xi + ` href="group/0/root.xml"/>`,
+					"</set>",
+				}, ready: true, envelope: true, element: "set"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		splitter := XMLSplitter{conf: Config{depth: 2, envelope: tt.envelope}}
+		cache := &processCache{
+			currentDirectory: []string{"out", "data"},
+			directoryCounter: make(map[string]int),
+			fileCounter:      make(map[string]int),
+		}
+		splitter.processLine(`<set version="2">Intro<meta/><group id="g">`, cache)
+		splitter.processLine(`<item>a</item> <!-- note --> <item>b</item>`, cache)
+		splitter.processLine(`</group></set>`, cache)
+
+		s.Assert().Equal(ioAction{actionType: openEnvelope, path: "out/data/set/0/root.xml", lines: []string{xml.Header + `<set version="2"/>`}, ready: true, envelope: true}, cache.ioActions[1], tt.envelope)
+		var envelopes []ioAction
+		for _, action := range cache.ioActions {
+			if action.actionType == writeFile && action.envelope {
+				envelopes = append(envelopes, action)
+			}
+		}
+		s.Assert().Equal(tt.want, envelopes, tt.envelope)
+		s.Assert().Empty(cache.envelopes)
+	}
+}
+
+func (s *SplitterSuite) TestProcessLineParents() {
+	tests := []struct {
+		parentKey string
+		want      []relation
+		parent    string
+	}{
+		{
+			want: []relation{
+				{parent: "data/set/0", child: "data/set/0/meta/0", element: "meta"},
+				{parent: "data/set/0", child: "data/set/0/group/0", element: "group"},
+				{parent: "data/set/0/group/0", child: "data/set/0/group/0/item.0", element: "item"},
+				{parent: "data/set/0/group/0", child: "data/set/0/group/0/item.1", element: "item"},
+			},
+			parent: "data/set/0/group/0",
+		},
+		{
+			parentKey: "@id",
+			want: []relation{
+				{parent: "data/set/0", child: "data/set/0/meta/0", element: "meta"},
+				{parent: "data/set/0", child: "g", element: "group"},
+				{parent: "g", child: "data/set/0/group/0/item.0", element: "item"},
+				{parent: "g", child: "data/set/0/group/0/item.1", element: "item"},
+			},
+			parent: "g",
+		},
+	}
+	for _, tt := range tests {
+		splitter := XMLSplitter{conf: Config{out: "out", depth: 2, parents: true, parentKey: tt.parentKey, provenanceNamespace: "urn:p", provenancePrefix: "p"}}
+		cache := &processCache{
+			currentDirectory: []string{"out", "data"},
+			directoryCounter: make(map[string]int),
+			fileCounter:      make(map[string]int),
+		}
+		splitter.processLine(`<set><meta/><group id="g">`, cache)
+		splitter.processLine(`<item>a</item><item n="2"/>`, cache)
+		splitter.processLine(`</group></set>`, cache)
+
+		var relations []relation
+		for _, action := range cache.ioActions {
+			if action.relation != nil {
+				relations = append(relations, *action.relation)
+			}
+		}
+		s.Assert().Equal(tt.want, relations, tt.parentKey)
+		s.Assert().Equal([]string{xml.Header, `<item xmlns:p="urn:p" p:parent="` + tt.parent + `">`, "a", "</item>"}, cache.ioActions[len(cache.ioActions)-2].lines, tt.parentKey)
+		s.Assert().Equal([]string{xml.Header, `<item n="2" xmlns:p="urn:p" p:parent="` + tt.parent + `"/>`}, cache.ioActions[len(cache.ioActions)-1].lines, tt.parentKey)
+		s.Assert().Empty(cache.parents)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tarWriter streams ioActions into one or more tar archives instead of the filesystem.
+// Entries keep the paths the default writer would have used, relative to the output folder.
+// A new archive is started once the current one reaches the configured rollover size, and repeats
+// the headers of the directories holding its entries, so each archive can be extracted on its own.
+type tarWriter struct {
+	out     string
+	base    string
+	gzipped bool
+	limit   int64
+	index   int
+	file    *os.File
+	counter *countingWriter
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	listed  outputList
+	// directories are the names of every directory written, and headed those with a header in
+	// the current archive.
+	directories map[string]bool
+	headed      map[string]bool
+}
+
+func newTarWriter(conf Config, source string) *tarWriter {
+	return &tarWriter{
+		out:         conf.out,
+		base:        filepath.Join(conf.out, sourceName(source)),
+		gzipped:     conf.archive == "tar.gz",
+		limit:       conf.rollover,
+		directories: make(map[string]bool),
+	}
+}
+
+func (w *tarWriter) write(actions []ioAction) ([]ioAction, error) {
+	for len(actions) > 0 && actions[0].ready {
+		if err := w.rollover(); err != nil {
+			return nil, err
+		}
+		action := actions[0]
+		switch action.actionType {
+		case writeFile:
+			name := relativePath(w.out, action.path)
+			if err := w.writeParents(name); err != nil {
+				return nil, err
+			}
+			content := strings.Join(action.lines, "")
+			header := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     0644,
+				Size:     int64(len(content)),
+				ModTime:  time.Now(),
+			}
+			if err := w.tw.WriteHeader(header); err != nil {
+				return nil, err
+			}
+			if _, err := io.WriteString(w.tw, content); err != nil {
+				return nil, err
+			}
+			if !action.envelope && !action.sidecar {
+				w.listed.count(1)
+			}
+		case newDirectory:
+			name := relativePath(w.out, action.path)
+			if err := w.writeParents(name); err != nil {
+				return nil, err
+			}
+			if err := w.writeDirectory(name + "/"); err != nil {
+				return nil, err
+			}
+		}
+		actions = actions[1:]
+	}
+	return actions, nil
+}
+
+// writeParents writes the header of each directory written before that holds the entry name, but
+// has no header in the current archive as it was written to an earlier one.
+func (w *tarWriter) writeParents(name string) error {
+	for i := range name {
+		if dir := name[:i+1]; name[i] == '/' && w.directories[dir] && !w.headed[dir] {
+			if err := w.writeDirectory(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *tarWriter) writeDirectory(name string) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  time.Now(),
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	w.directories[name], w.headed[name] = true, true
+	return nil
+}
+
+// rollover opens the first archive, or the next one when the current archive has reached the size limit.
+func (w *tarWriter) rollover() error {
+	if w.tw != nil && (w.limit <= 0 || w.counter.n < w.limit) {
+		return nil
+	}
+	if w.tw != nil {
+		if err := w.close(); err != nil {
+			return err
+		}
+		w.index++
+	}
+	ext := "tar"
+	if w.gzipped {
+		ext = "tar.gz"
+	}
+	path := fmt.Sprintf("%s.%d.%s", w.base, w.index, ext)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.counter = newCountingWriter(file)
+	w.headed = make(map[string]bool)
+	w.listed.open(path, w.counter)
+	if w.gzipped {
+		w.gz = gzip.NewWriter(w.counter)
+		w.tw = tar.NewWriter(w.gz)
+	} else {
+		w.tw = tar.NewWriter(w.counter)
+	}
+	return nil
+}
+
+func (w *tarWriter) close() error {
+	if w.tw == nil {
+		return nil
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			return err
+		}
+	}
+	err := w.file.Close()
+	w.listed.close()
+	w.tw, w.gz, w.file = nil, nil, nil
+	return err
+}
+
+func (w *tarWriter) outputs() []output {
+	return w.listed.take()
+}
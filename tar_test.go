@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TarSuite struct {
+	tempDirSuite
+}
+
+func TestTarSuite(t *testing.T) {
+	suite.Run(t, new(TarSuite))
+}
+
+func (s *TarSuite) actions() []ioAction {
+	return []ioAction{
+		{actionType: newDirectory, path: s.dir + "/sprot/uniprot/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/root.xml", lines: []string{"<uniprot/>"}, ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/entry.0.xml", lines: []string{"<entry>", "a", "</entry>"}, ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/uniprot/0/entry.1.xml", lines: []string{"<entry>", "b"}},
+	}
+}
+
+func (s *TarSuite) readArchive(path string, gzipped bool) map[string]string {
+	file, err := os.Open(path)
+	s.Require().NoError(err)
+	defer file.Close()
+	var r io.Reader = file
+	if gzipped {
+		r, err = gzip.NewReader(file)
+		s.Require().NoError(err)
+	}
+	entries := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+		content, err := ioutil.ReadAll(tr)
+		s.Require().NoError(err)
+		entries[header.Name] = string(content)
+	}
+	return entries
+}
+
+func (s *TarSuite) TestWrite() {
+	tests := []struct {
+		archive string
+		gzipped bool
+	}{
+		{archive: "tar"},
+		{archive: "tar.gz", gzipped: true},
+	}
+	for _, tt := range tests {
+		w := newTarWriter(Config{out: s.dir, archive: tt.archive}, "in/sprot.xml")
+		remaining, err := w.write(s.actions())
+		s.Require().NoError(err)
+		s.Assert().Len(remaining, 1)
+		s.Require().NoError(w.close())
+
+		entries := s.readArchive(filepath.Join(s.dir, "sprot.0."+tt.archive), tt.gzipped)
+		s.Assert().Equal(map[string]string{
+			"sprot/uniprot/0/":            "",
+			"sprot/uniprot/0/root.xml":    "<uniprot/>",
+			"sprot/uniprot/0/entry.0.xml": "<entry>a</entry>",
+		}, entries)
+	}
+}
+
+func (s *TarSuite) TestRollover() {
+	w := newTarWriter(Config{out: s.dir, archive: "tar", rollover: 1}, "in/sprot.xml")
+	_, err := w.write(s.actions())
+	s.Require().NoError(err)
+	s.Require().NoError(w.close())
+
+	// The directory is repeated in every archive holding its files.
+	s.Assert().Equal(map[string]string{"sprot/uniprot/0/": ""}, s.readArchive(filepath.Join(s.dir, "sprot.0.tar"), false))
+	s.Assert().Equal(map[string]string{"sprot/uniprot/0/": "", "sprot/uniprot/0/root.xml": "<uniprot/>"}, s.readArchive(filepath.Join(s.dir, "sprot.1.tar"), false))
+	s.Assert().Equal(map[string]string{"sprot/uniprot/0/": "", "sprot/uniprot/0/entry.0.xml": "<entry>a</entry>"}, s.readArchive(filepath.Join(s.dir, "sprot.2.tar"), false))
+}
+
+func (s *TarSuite) TestMissingOutputFolder() {
+	out := filepath.Join(s.dir, "missing", "out")
+	w, err := newWriter(Config{out: out, archive: "tar"}, "in/sprot.xml")
+	s.Require().NoError(err)
+	_, err = w.write([]ioAction{{actionType: writeFile, path: out + "/sprot/uniprot/0/entry.0.xml", lines: []string{"<entry/>"}, ready: true}})
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	s.Assert().Equal(map[string]string{"sprot/uniprot/0/entry.0.xml": "<entry/>"}, s.readArchive(filepath.Join(out, "sprot.0.tar"), false))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateFormat renders each record through a text/template file given by -template. The template
+// is executed with a templateRecord, the record root along with its key and source, and can use the
+// functions in templateFuncs. Rendered records take the extension the template file has once .tmpl
+// is removed, e.g. card.md.tmpl renders to .md files, or .txt if there is none.
+type templateFormat struct {
+	template *template.Template
+	ext      string
+}
+
+func newTemplateFormat(conf Config) (*templateFormat, error) {
+	if conf.template == "" {
+		return nil, errors.New("-template must be provided for the template format")
+	}
+	name := filepath.Base(conf.template)
+	t, err := template.New(name).Funcs(templateFuncs).ParseFiles(conf.template)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name)))
+	if ext == "" {
+		ext = ".txt"
+	}
+	return &templateFormat{template: t, ext: ext}, nil
+}
+
+func (f *templateFormat) extension() string {
+	return f.ext
+}
+
+func (f *templateFormat) streamExtension() string {
+	return f.ext
+}
+
+func (f *templateFormat) render(rec record) ([]byte, error) {
+	var b bytes.Buffer
+	data := templateRecord{templateNode: templateNode{rec.root}, Key: rec.key, Source: rec.source}
+	if err := f.template.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("%s: %v", rec.key, err)
+	}
+	return b.Bytes(), nil
+}
+
+// renderLine returns the rendered record without a trailing newline, or nil if the template
+// rendered nothing for it.
+func (f *templateFormat) renderLine(rec record) ([]byte, error) {
+	content, err := f.render(rec)
+	if err != nil || len(bytes.TrimSpace(content)) == 0 {
+		return nil, err
+	}
+	return bytes.TrimRight(content, "\r\n"), nil
+}
+
+// templateRecord is the data templates are executed with: the record root, whose methods are
+// available directly, along with the record's key and source file.
+type templateRecord struct {
+	templateNode
+	Key    string
+	Source string
+}
+
+// templateNode exposes an element of a record to templates.
+type templateNode struct {
+	n *node
+}
+
+// templateElement is implemented by templateNode and templateRecord, so either can be passed to
+// the template functions.
+type templateElement interface {
+	element() *node
+}
+
+func (t templateNode) element() *node {
+	return t.n
+}
+
+// Name is the element name as written, including any prefix.
+func (t templateNode) Name() string {
+	return t.n.qualifiedName()
+}
+
+// LocalName is the element name without any prefix.
+func (t templateNode) LocalName() string {
+	return t.n.name.Local
+}
+
+// Attr returns the value of the named attribute, or an empty string.
+func (t templateNode) Attr(name string) string {
+	value, _ := t.n.attr(name)
+	return value
+}
+
+// Attrs returns the element's attributes keyed by name.
+func (t templateNode) Attrs() map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range t.n.attrs {
+		attrs[qualifiedName(attr.Name)] = attr.Value
+	}
+	return attrs
+}
+
+// Text is the concatenated text of the element and its descendants.
+func (t templateNode) Text() string {
+	return t.n.innerText()
+}
+
+// Path is the record-relative path of the element.
+func (t templateNode) Path() string {
+	return t.n.path()
+}
+
+// Children returns the child elements.
+func (t templateNode) Children() []templateNode {
+	return templateNodes(t.n.elements())
+}
+
+// Find returns the elements matching a record-relative path.
+func (t templateNode) Find(path string) []templateNode {
+	return templateNodes(t.n.find(path))
+}
+
+// Value returns the first value matching a record-relative path, or an empty string.
+func (t templateNode) Value(path string) string {
+	return t.n.value(path)
+}
+
+// Values returns the values matching a record-relative path.
+func (t templateNode) Values(path string) []string {
+	return t.n.values(path)
+}
+
+// XML serialises the element and its descendants.
+func (t templateNode) XML() string {
+	return t.n.xmlString()
+}
+
+func templateNodes(nodes []*node) []templateNode {
+	result := make([]templateNode, len(nodes))
+	for i, n := range nodes {
+		result[i] = templateNode{n}
+	}
+	return result
+}
+
+// templateFuncs are the functions available to templates in addition to the text/template built-ins.
+var templateFuncs = template.FuncMap{
+	"value": func(e templateElement, path string) string {
+		return e.element().value(path)
+	},
+	"values": func(e templateElement, path string) []string {
+		return e.element().values(path)
+	},
+	"find": func(e templateElement, path string) []templateNode {
+		return templateNodes(e.element().find(path))
+	},
+	"attr": func(e templateElement, name string) string {
+		value, _ := e.element().attr(name)
+		return value
+	},
+	"text": func(e templateElement) string {
+		return e.element().innerText()
+	},
+	"join": func(separator string, values []string) string {
+		return strings.Join(values, separator)
+	},
+	"trim": strings.TrimSpace,
+	"escapeXML": func(value string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(value))
+		return b.String()
+	},
+	"quoteJSON": func(value string) (string, error) {
+		quoted, err := marshalJSON(value, false)
+		return string(quoted), err
+	},
+	"quoteSQL": func(value string) string {
+		return "'" + strings.Replace(value, "'", "''", -1) + "'"
+	},
+	"escapeMarkdown": func(value string) string {
+		var b strings.Builder
+		for _, r := range value {
+			if strings.ContainsRune("\\`*_{}[]()<>#+-.!|", r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	},
+}
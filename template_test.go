@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TemplateSuite struct {
+	tempDirSuite
+}
+
+func TestTemplateSuite(t *testing.T) {
+	suite.Run(t, new(TemplateSuite))
+}
+
+func (s *TemplateSuite) format(name, content string) *templateFormat {
+	path := filepath.Join(s.dir, name)
+	s.Require().NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	f, err := newTemplateFormat(Config{template: path})
+	s.Require().NoError(err)
+	return f
+}
+
+func (s *TemplateSuite) TestRender() {
+	root, err := parseRecord(`<PubmedArticle><MedlineCitation Status="MEDLINE"><PMID>123</PMID><Article><ArticleTitle>O'Brien &amp; <i>friends</i></ArticleTitle><AuthorList><Author><LastName>Smith</LastName></Author><Author><LastName>Jones</LastName></Author></AuthorList></Article></MedlineCitation></PubmedArticle>`, nil)
+	s.Require().NoError(err)
+	rec := record{key: "pubmed/PubmedArticleSet/0/PubmedArticle.0", source: "in/pubmed.xml", root: root}
+	tests := []struct {
+		name     string
+		template string
+		ext      string
+		want     string
+	}{
+		{
+			name:     "insert.sql.tmpl",
+			template: `INSERT INTO article VALUES ({{value . "MedlineCitation/PMID"}}, {{quoteSQL (value . "MedlineCitation/Article/ArticleTitle")}});` + "\n",
+			ext:      ".sql",
+			want:     "INSERT INTO article VALUES (123, 'O''Brien & friends');\n",
+		},
+		{
+			name:     "card.md.tmpl",
+			template: "# {{.Value \"MedlineCitation/PMID\"}}\n\n{{values . \"**/LastName\" | join \", \"}} ({{.Key}})\n{{range find . \"**/Author\"}}- {{escapeMarkdown (text .)}}\n{{end}}",
+			ext:      ".md",
+			want:     "# 123\n\nSmith, Jones (pubmed/PubmedArticleSet/0/PubmedArticle.0)\n- Smith\n- Jones\n",
+		},
+		{
+			name:     "subset.xml.tmpl",
+			template: `<article status="{{escapeXML (attr (index (find . "MedlineCitation") 0) "Status")}}">{{(index (.Find "MedlineCitation/Article/ArticleTitle") 0).XML}}</article>`,
+			ext:      ".xml",
+			want:     `<article status="MEDLINE"><ArticleTitle>O&#39;Brien &amp; <i>friends</i></ArticleTitle></article>`,
+		},
+		{
+			name:     "doc.tmpl",
+			template: `{"name":{{quoteJSON .Name}},"source":{{quoteJSON .Source}},"children":{{len .Children}}}`,
+			ext:      ".txt",
+			want:     `{"name":"PubmedArticle","source":"in/pubmed.xml","children":1}`,
+		},
+	}
+	for _, tt := range tests {
+		f := s.format(tt.name, tt.template)
+		s.Assert().Equal(tt.ext, f.extension(), tt.name)
+		content, err := f.render(rec)
+		s.Require().NoError(err, tt.name)
+		s.Assert().Equal(tt.want, string(content), tt.name)
+	}
+}
+
+func (s *TemplateSuite) TestRenderLine() {
+	root, err := parseRecord(`<entry><name>a</name></entry>`, nil)
+	s.Require().NoError(err)
+	f := s.format("line.tmpl", "{{with .Value \"name\"}}{{.}}\n{{end}}")
+	line, err := f.renderLine(record{root: root})
+	s.Require().NoError(err)
+	s.Assert().Equal("a", string(line))
+
+	root, err = parseRecord(`<entry/>`, nil)
+	s.Require().NoError(err)
+	line, err = f.renderLine(record{root: root})
+	s.Require().NoError(err)
+	s.Assert().Nil(line)
+}
+
+func (s *TemplateSuite) TestErrors() {
+	_, err := newTemplateFormat(Config{})
+	s.Assert().Error(err)
+	_, err = newTemplateFormat(Config{template: filepath.Join(s.dir, "missing.tmpl")})
+	s.Assert().Error(err)
+
+	root, err := parseRecord(`<entry/>`, nil)
+	s.Require().NoError(err)
+	_, err = s.format("bad.tmpl", "{{.Missing}}").render(record{key: "k", root: root})
+	s.Assert().Error(err)
+}
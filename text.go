@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textBreak separates the text of block elements.
+const textBreak = "\n\n"
+
+// textSpan locates an element within extracted text, as code point offsets.
+type textSpan struct {
+	node  *node
+	start int
+	end   int
+}
+
+// textExtractor extracts the readable text of a record. Entities are decoded by the parser and runs
+// of whitespace are collapsed to a single space. Block elements are separated from surrounding text
+// by a blank line, and skipped elements are left out entirely. Element names are matched either as
+// written or by their local name.
+type textExtractor struct {
+	blocks map[string]bool
+	skip   map[string]bool
+}
+
+func newTextExtractor(conf Config) *textExtractor {
+	e := &textExtractor{blocks: make(map[string]bool), skip: make(map[string]bool)}
+	for _, name := range conf.textBlocks {
+		e.blocks[name] = true
+	}
+	for _, name := range conf.textSkip {
+		e.skip[name] = true
+	}
+	return e
+}
+
+// extract returns the text of root along with the span of every element that was not skipped.
+func (e *textExtractor) extract(root *node) (string, []textSpan) {
+	state := &textState{}
+	e.walk(root, state)
+	text := strings.TrimSuffix(state.b.String(), textBreak)
+	length := utf8.RuneCountInString(text)
+	for i := range state.spans {
+		if state.spans[i].end > length {
+			state.spans[i].end = length
+		}
+		if state.spans[i].start > length {
+			state.spans[i].start = length
+		}
+	}
+	return text, state.spans
+}
+
+func (e *textExtractor) matches(names map[string]bool, n *node) bool {
+	return names[n.qualifiedName()] || names[n.name.Local]
+}
+
+func (e *textExtractor) walk(n *node, state *textState) {
+	if !n.isElement() {
+		state.writeText(n.text)
+		return
+	}
+	if e.matches(e.skip, n) {
+		return
+	}
+	isBlock := e.matches(e.blocks, n)
+	if isBlock {
+		state.writeBreak()
+	}
+	state.flushSpace()
+	index := len(state.spans)
+	state.spans = append(state.spans, textSpan{node: n, start: state.length})
+	for _, child := range n.children {
+		e.walk(child, state)
+	}
+	state.spans[index].end = state.length
+	if isBlock {
+		state.writeBreak()
+	}
+}
+
+// textState accumulates extracted text and its length in code points. Whitespace is held back
+// until more text follows so that none is written at the start or end of a block.
+type textState struct {
+	b            strings.Builder
+	length       int
+	spans        []textSpan
+	atBreak      bool
+	pendingSpace bool
+}
+
+func (s *textState) write(text string) {
+	s.b.WriteString(text)
+	s.length += utf8.RuneCountInString(text)
+}
+
+func (s *textState) writeBreak() {
+	s.pendingSpace = false
+	if s.length > 0 && !s.atBreak {
+		s.write(textBreak)
+		s.atBreak = true
+	}
+}
+
+// flushSpace writes any whitespace held back, unless at the start of a block.
+func (s *textState) flushSpace() {
+	if s.pendingSpace && s.length > 0 && !s.atBreak && !strings.HasSuffix(s.b.String(), " ") {
+		s.write(" ")
+	}
+	s.pendingSpace = false
+}
+
+func (s *textState) writeText(text string) {
+	collapsed := strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+	if collapsed == "" {
+		s.pendingSpace = s.pendingSpace || text != ""
+		return
+	}
+	first, _ := utf8.DecodeRuneInString(text)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	s.pendingSpace = s.pendingSpace || unicode.IsSpace(first)
+	s.flushSpace()
+	s.write(collapsed)
+	s.atBreak = false
+	s.pendingSpace = unicode.IsSpace(last)
+}
+
+// textFormat writes the extracted text of each record to a .txt file, or with -stream as JSON lines
+// holding the record key as id alongside the text.
+type textFormat struct {
+	extractor *textExtractor
+}
+
+func newTextFormat(conf Config) *textFormat {
+	return &textFormat{extractor: newTextExtractor(conf)}
+}
+
+func (f *textFormat) extension() string {
+	return ".txt"
+}
+
+func (f *textFormat) streamExtension() string {
+	return ".txt.jsonl"
+}
+
+func (f *textFormat) render(rec record) ([]byte, error) {
+	text, _ := f.extractor.extract(rec.root)
+	return []byte(text), nil
+}
+
+func (f *textFormat) renderLine(rec record) ([]byte, error) {
+	text, _ := f.extractor.extract(rec.root)
+	return marshalJSON(jsonObject{{key: "id", value: rec.key}, {key: "text", value: text}}, false)
+}
+
+// standoffFormat writes the extracted text of each record to a .txt file alongside a .ann.jsonl
+// stand-off annotation file. The annotation file holds a line per element, in document order, of
+// {"tag", "attributes", "start", "end"}, where start and end are code point offsets into the text
+// such that the element covers text[start:end]. With -stream each record is instead written as a
+// single line holding its key, text and annotations.
+type standoffFormat struct {
+	extractor *textExtractor
+}
+
+func newStandoffFormat(conf Config) *standoffFormat {
+	return &standoffFormat{extractor: newTextExtractor(conf)}
+}
+
+func (f *standoffFormat) extension() string {
+	return ".txt"
+}
+
+func (f *standoffFormat) streamExtension() string {
+	return ".standoff.jsonl"
+}
+
+func (f *standoffFormat) render(rec record) ([]byte, error) {
+	text, _ := f.extractor.extract(rec.root)
+	return []byte(text), nil
+}
+
+// renderWithSidecar returns the text together with the annotation file written alongside it.
+func (f *standoffFormat) renderWithSidecar(rec record) ([]byte, string, []byte, error) {
+	text, spans := f.extractor.extract(rec.root)
+	var lines [][]byte
+	for _, span := range spans {
+		line, err := marshalJSON(f.annotation(span), false)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		lines = append(lines, line)
+	}
+	return []byte(text), ".ann.jsonl", append(bytes.Join(lines, []byte("\n")), '\n'), nil
+}
+
+func (f *standoffFormat) renderLine(rec record) ([]byte, error) {
+	text, spans := f.extractor.extract(rec.root)
+	annotations := make([]interface{}, len(spans))
+	for i, span := range spans {
+		annotations[i] = f.annotation(span)
+	}
+	return marshalJSON(jsonObject{
+		{key: "id", value: rec.key},
+		{key: "text", value: text},
+		{key: "annotations", value: annotations},
+	}, false)
+}
+
+func (f *standoffFormat) annotation(span textSpan) jsonObject {
+	attributes := jsonObject{}
+	for _, attr := range span.node.attrs {
+		attributes = append(attributes, jsonField{key: qualifiedName(attr.Name), value: attr.Value})
+	}
+	return jsonObject{
+		{key: "tag", value: span.node.qualifiedName()},
+		{key: "attributes", value: attributes},
+		{key: "start", value: span.start},
+		{key: "end", value: span.end},
+	}
+}
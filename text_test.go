@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type TextSuite struct {
+	suite.Suite
+}
+
+func TestTextSuite(t *testing.T) {
+	suite.Run(t, new(TextSuite))
+}
+
+func (s *TextSuite) TestExtract() {
+	tests := []struct {
+		name    string
+		conf    Config
+		content string
+		want    string
+	}{
+		{
+			name:    "blocks",
+			conf:    Config{textBlocks: []string{"p", "sec", "title"}},
+			content: `<article><title>Title</title><sec><title>Intro</title><p>First  para.</p><p>Second &amp; <i>last</i> para.</p></sec></article>`,
+			want:    "Title\n\nIntro\n\nFirst para.\n\nSecond & last para.",
+		},
+		{
+			name:    "skipped elements",
+			conf:    Config{textBlocks: []string{"p"}, textSkip: []string{"ref-list", "table-wrap"}},
+			content: `<article><p>Text<xref>1</xref>.</p><table-wrap><p>cell</p></table-wrap><ref-list><p>ref</p></ref-list></article>`,
+			want:    "Text1.",
+		},
+		{
+			name:    "whitespace",
+			conf:    Config{textBlocks: []string{"p"}},
+			content: "<article>\n  <p>  leading</p>  <p>a <b> b </b> c\n</p> tail &nbsp;</article>",
+			want:    "leading\n\na b c\n\ntail",
+		},
+		{
+			name:    "prefixed blocks match by local name",
+			conf:    Config{textBlocks: []string{"p"}},
+			content: `<x:doc xmlns:x="urn:x"><x:p>one</x:p><x:p>two</x:p></x:doc>`,
+			want:    "one\n\ntwo",
+		},
+	}
+	for _, tt := range tests {
+		root, err := parseRecord(tt.content, nil)
+		s.Require().NoError(err, tt.name)
+		text, _ := newTextExtractor(tt.conf).extract(root)
+		s.Assert().Equal(tt.want, text, tt.name)
+	}
+}
+
+func (s *TextSuite) TestRenderLine() {
+	root, err := parseRecord(`<p>text</p>`, nil)
+	s.Require().NoError(err)
+	line, err := newTextFormat(Config{}).renderLine(record{key: "out/a/p.0", root: root})
+	s.Require().NoError(err)
+	s.Assert().Equal(`{"id":"out/a/p.0","text":"text"}`, string(line))
+}
+
+func (s *TextSuite) TestStandoffSidecar() {
+	root, err := parseRecord(`<article><title>Ünïcode <i>ïtalic <b>bold</b></i></title><p>x<sup>2</sup></p><ref-list><p>r</p></ref-list></article>`, nil)
+	s.Require().NoError(err)
+	f := newStandoffFormat(Config{textBlocks: []string{"p", "title"}, textSkip: []string{"ref-list"}})
+
+	content, err := f.render(record{root: root})
+	s.Require().NoError(err)
+	s.Assert().Equal("Ünïcode ïtalic bold\n\nx2", string(content))
+
+	withSidecar, ext, annotations, err := f.renderWithSidecar(record{root: root})
+	s.Require().NoError(err)
+	s.Assert().Equal(content, withSidecar)
+	s.Assert().Equal(".ann.jsonl", ext)
+	s.Assert().Equal(`{"tag":"article","attributes":{},"start":0,"end":23}
+{"tag":"title","attributes":{},"start":0,"end":19}
+{"tag":"i","attributes":{},"start":8,"end":19}
+{"tag":"b","attributes":{},"start":15,"end":19}
+{"tag":"p","attributes":{},"start":21,"end":23}
+{"tag":"sup","attributes":{},"start":22,"end":23}
+`, string(annotations))
+
+	text := []rune(string(content))
+	_, spans := f.extractor.extract(root)
+	s.Assert().Equal("ïtalic bold", string(text[spans[2].start:spans[2].end]))
+	s.Assert().Equal("bold", string(text[spans[3].start:spans[3].end]))
+}
+
+func (s *TextSuite) TestStandoffFormatWriter() {
+	w := &formatWriter{format: newStandoffFormat(Config{}), conf: Config{out: "out"}, next: &mockWriter{}, scopes: make(recordScopes)}
+	w.next.(*mockWriter).On("write", []ioAction{
+		{actionType: writeFile, path: "out/a/p.0.txt", lines: []string{"text"}, ready: true},
+		{actionType: writeFile, path: "out/a/p.0.ann.jsonl", lines: []string{`{"tag":"p","attributes":{"id":"1"},"start":0,"end":4}` + "\n"}, ready: true, sidecar: true},
+	}).Return([]ioAction{}, nil)
+	_, err := w.write([]ioAction{{actionType: writeFile, path: "out/a/p.0.xml", lines: []string{`<p id="1">`, "text", "</p>"}, ready: true}})
+	s.Require().NoError(err)
+	w.next.(*mockWriter).AssertNumberOfCalls(s.T(), "write", 1)
+}
+
+func (s *TextSuite) TestSplitMixedContent() {
+	source := "<doc>\n  <p>The <b>quick</b> brown fox,\n  see <ref>1</ref>.</p>\n  <p><i>one</i>\n<i>two</i></p>\n</doc>\n"
+	conf := Config{out: "out", skip: regexp.MustCompile(defaultSkip), strip: regexp.MustCompile(""), depth: 1, buffer: 20, format: "standoff"}
+	splitter := XMLSplitter{path: "in/doc.xml", conf: conf}
+	writer := &mockWriter{}
+	writer.On("write", mock.Anything).Return([]ioAction{}, nil)
+	_, err := splitter.ProcessFile(bufio.NewScanner(strings.NewReader(source)), writer)
+	s.Require().NoError(err)
+
+	f := newStandoffFormat(conf)
+	var texts, annotations []string
+	for _, call := range writer.Calls {
+		for _, action := range call.Arguments.Get(0).([]ioAction) {
+			if action.actionType != writeFile || action.envelope {
+				continue
+			}
+			rec, err := newRecord(conf, splitter.path, action, nil)
+			s.Require().NoError(err)
+			text, _, sidecar, err := f.renderWithSidecar(rec)
+			s.Require().NoError(err)
+			texts, annotations = append(texts, string(text)), append(annotations, string(sidecar))
+		}
+	}
+	s.Equal([]string{"The quick brown fox, see 1.", "one two"}, texts)
+	s.Equal([]string{
+		`{"tag":"p","attributes":{},"start":0,"end":27}
+{"tag":"b","attributes":{},"start":4,"end":9}
+{"tag":"ref","attributes":{},"start":25,"end":26}
+`,
+		`{"tag":"p","attributes":{},"start":0,"end":7}
+{"tag":"i","attributes":{},"start":0,"end":3}
+{"tag":"i","attributes":{},"start":4,"end":7}
+`,
+	}, annotations)
+}
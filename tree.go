@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// node is an element, or a run of character data, within a parsed record.
+type node struct {
+	// name holds the namespace prefix as written in Space rather than the namespace URI.
+	name       xml.Name
+	attrs      []xml.Attr
+	text       string
+	children   []*node
+	parent     *node
+	namespaces map[string]string
+}
+
+// scope holds what a record inherits from ancestors that are not part of it: their namespace
+// declarations, keyed by prefix with the default namespace under the empty string, and inherited
+// attributes such as xml:lang and xml:base. It also holds the general entities declared in the
+// DOCTYPE of the source, keyed by name.
+type scope struct {
+	namespaces map[string]string
+	attrs      []xml.Attr
+	entities   map[string]string
+}
+
+// parseRecord parses the content of a split record into a tree and returns its root element.
+// The record's ancestors can be supplied in context, which may be nil.
+func parseRecord(content string, context *scope) (*node, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Entity = xml.HTMLEntity
+	document := &node{}
+	if context != nil {
+		document.namespaces, document.attrs = context.namespaces, context.attrs
+		if len(context.entities) > 0 {
+			decoder.Entity = make(map[string]string, len(xml.HTMLEntity)+len(context.entities))
+			for name, value := range xml.HTMLEntity {
+				decoder.Entity[name] = value
+			}
+			for name, value := range context.entities {
+				decoder.Entity[name] = value
+			}
+		}
+	}
+	current := document
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child := &node{name: t.Name, attrs: t.Copy().Attr, parent: current}
+			for _, attr := range child.attrs {
+				if isNamespaceDeclaration(attr) {
+					if child.namespaces == nil {
+						child.namespaces = make(map[string]string)
+					}
+					if attr.Name.Space == "" {
+						child.namespaces[""] = attr.Value
+					} else {
+						child.namespaces[attr.Name.Local] = attr.Value
+					}
+				}
+			}
+			current.children = append(current.children, child)
+			current = child
+		case xml.EndElement:
+			if current.parent == nil {
+				return nil, &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">"}
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != document {
+				current.children = append(current.children, &node{text: string(t), parent: current})
+			}
+		}
+	}
+	for _, child := range document.children {
+		if child.isElement() {
+			return child, nil
+		}
+	}
+	return nil, &xml.SyntaxError{Msg: "record contains no elements"}
+}
+
+func (n *node) isElement() bool {
+	return n.name.Local != ""
+}
+
+// qualifiedName is the element name as written, including any prefix.
+func (n *node) qualifiedName() string {
+	return qualifiedName(n.name)
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// namespace resolves a prefix to its namespace URI using the declarations in scope at n.
+func (n *node) namespace(prefix string) string {
+	if prefix == "xml" {
+		return xmlNamespace
+	}
+	for current := n; current != nil; current = current.parent {
+		if uri, ok := current.namespaces[prefix]; ok {
+			return uri
+		}
+	}
+	return ""
+}
+
+// isNamespaceDeclaration reports whether attr declares a namespace.
+func isNamespaceDeclaration(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns")
+}
+
+// elements returns the child elements of n.
+func (n *node) elements() []*node {
+	var elements []*node
+	for _, child := range n.children {
+		if child.isElement() {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// attr returns the value of the attribute with the given qualified name.
+func (n *node) attr(name string) (string, bool) {
+	for _, attr := range n.attrs {
+		if qualifiedName(attr.Name) == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// innerText is the concatenated character data of n and its descendants.
+func (n *node) innerText() string {
+	if !n.isElement() {
+		return n.text
+	}
+	var b strings.Builder
+	for _, child := range n.children {
+		b.WriteString(child.innerText())
+	}
+	return b.String()
+}
+
+// ownText is the concatenated character data directly within n, excluding its descendants.
+func (n *node) ownText() string {
+	var b strings.Builder
+	for _, child := range n.children {
+		if !child.isElement() {
+			b.WriteString(child.text)
+		}
+	}
+	return b.String()
+}
+
+// path is the slash separated list of element names from the record root to n, excluding the root.
+func (n *node) path() string {
+	var names []string
+	for current := n; current.parent != nil && current.parent.isElement(); current = current.parent {
+		names = append([]string{current.qualifiedName()}, names...)
+	}
+	return strings.Join(names, "/")
+}
+
+// position is the 1-based index of n among its siblings with the same name.
+func (n *node) position() int {
+	if n.parent == nil {
+		return 1
+	}
+	position := 0
+	for _, sibling := range n.parent.elements() {
+		if sibling.name == n.name {
+			position++
+		}
+		if sibling == n {
+			break
+		}
+	}
+	return position
+}
+
+// find returns the elements matching a record-relative path. Paths are slash separated element
+// names, as written including any prefix, relative to the record root. "*" matches any element,
+// "**" matches any number of nested elements, including none, and "." or an empty path refers to
+// the root itself. A step may be followed by a predicate, name[@attr=value], to only match elements
+// with that attribute value.
+func (n *node) find(path string) []*node {
+	matches := []*node{n}
+	for _, step := range strings.Split(strings.Trim(path, "/"), "/") {
+		if step == "" || step == "." {
+			continue
+		}
+		if step == "**" {
+			matches = descendantsOrSelf(matches)
+			continue
+		}
+		name, attr, value := parseStep(step)
+		var next []*node
+		for _, match := range matches {
+			for _, child := range match.elements() {
+				if name != "*" && child.qualifiedName() != name {
+					continue
+				}
+				if actual, ok := child.attr(attr); attr != "" && (!ok || actual != value) {
+					continue
+				}
+				next = append(next, child)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// parseStep splits a path step into its element name and the attribute and value of any predicate.
+func parseStep(step string) (name, attr, value string) {
+	i := strings.Index(step, "[@")
+	if i < 0 || !strings.HasSuffix(step, "]") {
+		return step, "", ""
+	}
+	name, predicate := step[:i], step[i+2:len(step)-1]
+	if j := strings.Index(predicate, "="); j >= 0 {
+		return name, predicate[:j], strings.Trim(predicate[j+1:], `'"`)
+	}
+	return name, predicate, ""
+}
+
+// descendantsOrSelf returns the given elements and all of their descendant elements, once each.
+func descendantsOrSelf(elements []*node) []*node {
+	var result []*node
+	seen := make(map[*node]bool)
+	var visit func(n *node)
+	visit = func(n *node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		result = append(result, n)
+		for _, child := range n.elements() {
+			visit(child)
+		}
+	}
+	for _, n := range elements {
+		visit(n)
+	}
+	return result
+}
+
+// splitAttributePath splits a path ending in @name into the path of the elements and the attribute
+// name. The attribute is empty for paths selecting elements.
+func splitAttributePath(path string) (string, string) {
+	i := strings.LastIndex(path, "@")
+	if i < 0 || i < strings.LastIndex(path, "]") {
+		return path, ""
+	}
+	return strings.TrimSuffix(path[:i], "/"), path[i+1:]
+}
+
+// values returns the values matching a record-relative path. A path ending in @name selects the
+// named attribute of each matching element, otherwise the inner text of each element is returned.
+func (n *node) values(path string) []string {
+	path, attr := splitAttributePath(path)
+	var values []string
+	for _, match := range n.find(path) {
+		if attr == "" {
+			values = append(values, match.innerText())
+		} else if value, ok := match.attr(attr); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// value returns the first value matching a record-relative path, or an empty string.
+func (n *node) value(path string) string {
+	if values := n.values(path); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// inherited returns the value of the named attribute on n or the nearest ancestor that has it,
+// as used for xml:lang and xml:base.
+func (n *node) inherited(name string) (string, bool) {
+	for current := n; current != nil; current = current.parent {
+		if value, ok := current.attr(name); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// xmlString serialises n, and its descendants, back to XML.
+func (n *node) xmlString() string {
+	var b bytes.Buffer
+	n.writeXML(&b)
+	return b.String()
+}
+
+func (n *node) writeXML(b *bytes.Buffer) {
+	if !n.isElement() {
+		xml.EscapeText(b, []byte(n.text))
+		return
+	}
+	b.WriteString("<" + n.qualifiedName())
+	for _, attr := range n.attrs {
+		b.WriteString(" " + qualifiedName(attr.Name) + `="`)
+		xml.EscapeText(b, []byte(attr.Value))
+		b.WriteString(`"`)
+	}
+	if len(n.children) == 0 {
+		b.WriteString("/>")
+		return
+	}
+	b.WriteString(">")
+	for _, child := range n.children {
+		child.writeXML(b)
+	}
+	b.WriteString("</" + n.qualifiedName() + ">")
+}
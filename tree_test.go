@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TreeSuite struct {
+	suite.Suite
+}
+
+func TestTreeSuite(t *testing.T) {
+	suite.Run(t, new(TreeSuite))
+}
+
+const treeRecord = `<?xml version="1.0" encoding="UTF-8"?>
+<PubmedArticle><MedlineCitation Status="MEDLINE"><PMID Version="1">123</PMID><Article><ArticleTitle>A <i>title</i>&amp; more</ArticleTitle><AuthorList><Author><LastName>Smith</LastName></Author><Author><LastName>Jones</LastName></Author></AuthorList></Article></MedlineCitation></PubmedArticle>`
+
+func (s *TreeSuite) TestParseRecord() {
+	root, err := parseRecord(treeRecord, nil)
+	s.Require().NoError(err)
+	s.Assert().Equal("PubmedArticle", root.qualifiedName())
+	s.Assert().Len(root.elements(), 1)
+	s.Assert().Equal("123A title& moreSmithJones", root.innerText())
+
+	_, err = parseRecord("no elements", nil)
+	s.Assert().Error(err)
+	_, err = parseRecord("<a></b></a></c>", nil)
+	s.Assert().Error(err)
+}
+
+func (s *TreeSuite) TestValues() {
+	root, err := parseRecord(treeRecord, nil)
+	s.Require().NoError(err)
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "MedlineCitation/PMID", want: []string{"123"}},
+		{path: "/MedlineCitation/PMID/", want: []string{"123"}},
+		{path: "MedlineCitation/PMID/@Version", want: []string{"1"}},
+		{path: "MedlineCitation/@Status", want: []string{"MEDLINE"}},
+		{path: "MedlineCitation/Article/ArticleTitle", want: []string{"A title& more"}},
+		{path: "MedlineCitation/Article/AuthorList/Author/LastName", want: []string{"Smith", "Jones"}},
+		{path: "MedlineCitation/*/AuthorList/*/LastName", want: []string{"Smith", "Jones"}},
+		{path: "MedlineCitation/Missing", want: nil},
+		{path: "MedlineCitation/PMID/@Missing", want: nil},
+		{path: "**/LastName", want: []string{"Smith", "Jones"}},
+		{path: "**/**/LastName", want: []string{"Smith", "Jones"}},
+		{path: "**/@Version", want: []string{"1"}},
+		{path: "MedlineCitation/**/PMID", want: []string{"123"}},
+		{path: "MedlineCitation[@Status=MEDLINE]/PMID", want: []string{"123"}},
+		{path: "MedlineCitation[@Status='OTHER']/PMID", want: nil},
+		{path: "MedlineCitation/PMID[@Version=1]", want: []string{"123"}},
+		{path: "MedlineCitation/PMID[@Version=1]/@Version", want: []string{"1"}},
+	}
+	for _, tt := range tests {
+		s.Assert().Equal(tt.want, root.values(tt.path), tt.path)
+	}
+	s.Assert().Equal("Smith", root.value("MedlineCitation/Article/AuthorList/Author/LastName"))
+	s.Assert().Equal("", root.value("Missing"))
+	s.Assert().Equal("MedlineCitation/Article/AuthorList", root.find("MedlineCitation/Article/AuthorList")[0].path())
+}
+
+func (s *TreeSuite) TestEntities() {
+	content := `<Class about="&obo;GO_1">&eacute;&obo;</Class>`
+	_, err := parseRecord(content, nil)
+	s.Assert().Error(err)
+	root, err := parseRecord(content, &scope{entities: map[string]string{"obo": "http://purl.obolibrary.org/obo/"}})
+	s.Require().NoError(err)
+	s.Assert().Equal("http://purl.obolibrary.org/obo/GO_1", root.value("@about"))
+	s.Assert().Equal("éhttp://purl.obolibrary.org/obo/", root.innerText())
+}
+
+func (s *TreeSuite) TestNamespaces() {
+	root, err := parseRecord(`<a:entry xmlns:a="urn:a"><name xml:lang="en"><b:x xmlns:b="urn:b"/></name></a:entry>`, &scope{namespaces: map[string]string{"": "urn:default"}})
+	s.Require().NoError(err)
+	name := root.find("name")[0]
+	s.Assert().Equal("urn:a", root.namespace("a"))
+	s.Assert().Equal("urn:default", name.namespace(""))
+	s.Assert().Equal(xmlNamespace, name.namespace("xml"))
+	s.Assert().Equal("urn:b", name.find("b:x")[0].namespace("b"))
+	s.Assert().Equal("", name.namespace("b"))
+	s.Assert().Equal([]string{"en"}, root.values("name/@xml:lang"))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func verifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	in := flags.String("in", "", "the source file that was split")
+	out := flags.String("out", "", "the output folder it was split into")
+	depth := flags.Int("depth", 1, "the depth it was split at")
+	namespace := flags.String("provenance-namespace", defaultProvenanceNamespace, "the namespace of provenance and parent attributes, which are ignored")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(*in) == 0 || len(*out) == 0 {
+		flags.PrintDefaults()
+		return errors.New("values must be provided for -in and -out")
+	}
+	report, err := verifySplit(*in, strings.TrimRight(*out, "/"), *depth, *namespace)
+	if err != nil {
+		return err
+	}
+	for _, d := range report.discrepancies {
+		fmt.Println(d)
+	}
+	fmt.Printf("%d records verified, %d missing, %d extra, %d differing\n", report.verified, report.count("missing"), report.count("extra"), report.count("differing"))
+	if len(report.discrepancies) > 0 {
+		return fmt.Errorf("%d discrepancies found between %s and its split output", len(report.discrepancies), *in)
+	}
+	return nil
+}
+
+// verifyDiscrepancy is a record that is missing from the output, extra to it or differs from the
+// source. Records of the source are located by their ordinal and byte offset in it.
+type verifyDiscrepancy struct {
+	kind    string
+	key     string
+	ordinal int
+	offset  int64
+}
+
+func (d verifyDiscrepancy) String() string {
+	if d.kind == "extra" {
+		return fmt.Sprintf("%s\t%s", d.kind, d.key)
+	}
+	return fmt.Sprintf("%s\t%s\trecord %d at offset %d", d.kind, d.key, d.ordinal, d.offset)
+}
+
+type verifyReport struct {
+	verified      int
+	discrepancies []verifyDiscrepancy
+}
+
+func (r verifyReport) count(kind string) int {
+	n := 0
+	for _, d := range r.discrepancies {
+		if d.kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// verifySplit checks that every record of the source at path appears exactly once in its split
+// output in out, with the same content once both are canonicalised. Records are matched by the key
+// the splitter gives them, derived from the directory and file counters, so records written with
+// -owl, whose files are named after their IRI, cannot be verified.
+func verifySplit(path, out string, depth int, namespace string) (verifyReport, error) {
+	var report verifyReport
+	root := filepath.Join(out, sourceName(path))
+	outputs, err := outputDigests(out, root, namespace)
+	if err != nil {
+		return report, err
+	}
+
+	r, err := openSource(path)
+	if err != nil {
+		return report, err
+	}
+	defer r.Close()
+	err = sourceRecords(r, sourceName(path), depth, namespace, func(key string, ordinal int, offset int64, digest [sha256.Size]byte) {
+		output, ok := outputs[key]
+		switch {
+		case !ok:
+			report.discrepancies = append(report.discrepancies, verifyDiscrepancy{kind: "missing", key: key, ordinal: ordinal, offset: offset})
+		case output != digest:
+			report.discrepancies = append(report.discrepancies, verifyDiscrepancy{kind: "differing", key: key, ordinal: ordinal, offset: offset})
+		default:
+			report.verified++
+		}
+		delete(outputs, key)
+	})
+	if err != nil {
+		return report, fmt.Errorf("%s: %v", path, err)
+	}
+	var extra []string
+	for key := range outputs {
+		extra = append(extra, key)
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		report.discrepancies = append(report.discrepancies, verifyDiscrepancy{kind: "extra", key: key, ordinal: -1, offset: -1})
+	}
+	return report, nil
+}
+
+// outputDigests returns the digest of the canonical content of every record file under root, keyed
+// by its path relative to out without its extension.
+func outputDigests(out, root string, namespace string) (map[string][sha256.Size]byte, error) {
+	digests := make(map[string][sha256.Size]byte)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name := trimCompression(info.Name())
+		if !strings.HasSuffix(name, ".xml") || name == "root.xml" {
+			return nil
+		}
+		content, err := readSplitFile(path)
+		if err != nil {
+			return err
+		}
+		key := relativePath(out, filepath.ToSlash(strings.TrimSuffix(filepath.Join(filepath.Dir(path), name), ".xml")))
+		// Directories are laid out as <source>/<element>/<counter>/..., so ancestors are every other one.
+		var ancestors []string
+		dirs := strings.Split(relativePath(root, filepath.ToSlash(filepath.Dir(path))), "/")
+		for i := 0; i < len(dirs); i += 2 {
+			ancestors = append(ancestors, dirs[i])
+		}
+		c := newCanonicalizer(namespace)
+		d := xml.NewDecoder(strings.NewReader(unwrapRecord(content, ancestors)))
+		d.Strict = false
+		for {
+			t, err := d.RawToken()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			c.token(t)
+		}
+		digests[key] = sha256.Sum256(c.Bytes())
+		return nil
+	})
+	return digests, err
+}
+
+// sourceRecords reads the records at depth from r, calling emit with the key the splitter gives each
+// record, its ordinal and offset, and the digest of its canonical content.
+func sourceRecords(r io.Reader, source string, depth int, namespace string, emit func(key string, ordinal int, offset int64, digest [sha256.Size]byte)) error {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	dirs := []string{source}
+	counters := make(map[string]int)
+	level, ordinal := 0, 0
+	var c *canonicalizer
+	var key string
+	var offset int64
+	for {
+		start := d.InputOffset()
+		t, err := d.RawToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			name := qualifiedName(t.Name)
+			if level < depth {
+				dir := dirs[len(dirs)-1] + "/" + name
+				dirs = append(dirs, dir+"/"+strconv.Itoa(counters[dir]))
+				counters[dir]++
+			} else if level == depth {
+				file := dirs[len(dirs)-1] + "/" + name
+				key = file + "." + strconv.Itoa(counters[file])
+				counters[file]++
+				c, offset = newCanonicalizer(namespace), start
+			}
+			level++
+		case xml.EndElement:
+			level--
+			if level < depth {
+				dirs = dirs[:len(dirs)-1]
+			}
+		}
+		if c != nil {
+			c.token(t)
+			if _, ok := t.(xml.EndElement); ok && level == depth {
+				emit(key, ordinal, offset, sha256.Sum256(c.Bytes()))
+				ordinal++
+				c = nil
+			}
+		}
+	}
+}
+
+// canonicalizer serialises XML tokens so only content the splitter is documented to change does not
+// matter: attributes are sorted, text is trimmed and whitespace only text between tags dropped, as
+// the splitter does, and attributes in the provenance namespace are left out. Whitespace inside text
+// is compared exactly.
+type canonicalizer struct {
+	bytes.Buffer
+	namespace string
+}
+
+func newCanonicalizer(namespace string) *canonicalizer {
+	return &canonicalizer{namespace: namespace}
+}
+
+func (c *canonicalizer) token(t xml.Token) {
+	switch t := t.(type) {
+	case xml.StartElement:
+		ignored := make(map[string]bool)
+		for _, attr := range t.Attr {
+			if attr.Name.Space == "xmlns" && attr.Value == c.namespace {
+				ignored[attr.Name.Local] = true
+			}
+		}
+		var attrs []string
+		for _, attr := range t.Attr {
+			if ignored[attr.Name.Space] || attr.Name.Space == "xmlns" && ignored[attr.Name.Local] {
+				continue
+			}
+			var value bytes.Buffer
+			xml.EscapeText(&value, []byte(attr.Value))
+			attrs = append(attrs, qualifiedName(attr.Name)+`="`+value.String()+`"`)
+		}
+		sort.Strings(attrs)
+		c.WriteString("<" + qualifiedName(t.Name))
+		for _, attr := range attrs {
+			c.WriteString(" " + attr)
+		}
+		c.WriteString(">")
+	case xml.EndElement:
+		c.WriteString("</" + qualifiedName(t.Name) + ">")
+	case xml.CharData:
+		if text := bytes.TrimSpace(t); len(text) > 0 {
+			xml.EscapeText(c, text)
+		}
+	case xml.Comment:
+		c.WriteString("<!--" + string(t) + "-->")
+	case xml.ProcInst:
+		c.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type VerifySuite struct {
+	tempDirSuite
+}
+
+func TestVerifySuite(t *testing.T) {
+	suite.Run(t, new(VerifySuite))
+}
+
+const verifySource = `<set version="2">
+  <group id="g">
+    <item b="2"   a="1">  a b  </item>
+    <item n="2"/>
+  </group>
+  <group id="h"><item>c<!-- note --></item></group>
+</set>
+`
+
+// split writes verifySource to the temporary folder and splits it into <dir>/out with conf.
+func (s *VerifySuite) split(conf Config) (string, string) {
+	source := filepath.Join(s.dir, "set.xml")
+	s.Require().NoError(ioutil.WriteFile(source, []byte(verifySource), 0644))
+	conf.out = filepath.Join(s.dir, "out")
+	conf.skip = regexp.MustCompile(defaultSkip)
+	conf.strip = regexp.MustCompile("")
+	conf.buffer = 1
+	r, err := newRun("run")
+	s.Require().NoError(err)
+	splitter := XMLSplitter{path: source, conf: conf, run: r}
+	w, err := newWriter(conf, splitter.path)
+	s.Require().NoError(err)
+	_, err = splitter.ProcessFile(bufio.NewScanner(strings.NewReader(verifySource)), w)
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+	return source, conf.out
+}
+
+func (s *VerifySuite) TestVerify() {
+	tests := []struct {
+		name     string
+		conf     Config
+		change   func(out string)
+		verified int
+		want     []verifyDiscrepancy
+	}{
+		{
+			name:     "records",
+			conf:     Config{depth: 2},
+			verified: 3,
+		},
+		{
+			name:     "wrapped, compressed with provenance",
+			conf:     Config{depth: 2, wrap: true, compress: "gzip", provenance: "attributes", provenanceNamespace: defaultProvenanceNamespace, provenancePrefix: "xsp", parents: true},
+			verified: 3,
+		},
+		{
+			name: "discrepancies",
+			conf: Config{depth: 2},
+			change: func(out string) {
+				group := filepath.Join(out, "set", "set", "0", "group", "0")
+				s.Require().NoError(ioutil.WriteFile(filepath.Join(group, "item.0.xml"), []byte(`<item a="1" b="3">a</item>`), 0644))
+				s.Require().NoError(os.Remove(filepath.Join(group, "item.1.xml")))
+				s.Require().NoError(ioutil.WriteFile(filepath.Join(group, "item.2.xml"), []byte(`<item/>`), 0644))
+			},
+			verified: 1,
+			want: []verifyDiscrepancy{
+				{kind: "differing", key: "set/set/0/group/0/item.0", ordinal: 0, offset: 39},
+				{kind: "missing", key: "set/set/0/group/0/item.1", ordinal: 1, offset: 78},
+				{kind: "extra", key: "set/set/0/group/0/item.2", ordinal: -1, offset: -1},
+			},
+		},
+		{
+			name: "whitespace inside text",
+			conf: Config{depth: 2},
+			change: func(out string) {
+				group := filepath.Join(out, "set", "set", "0", "group", "0")
+				s.Require().NoError(ioutil.WriteFile(filepath.Join(group, "item.0.xml"), []byte(`<item b="2" a="1">a  b</item>`), 0644))
+			},
+			verified: 2,
+			want: []verifyDiscrepancy{
+				{kind: "differing", key: "set/set/0/group/0/item.0", ordinal: 0, offset: 39},
+			},
+		},
+	}
+	for _, test := range tests {
+		s.Run(test.name, func() {
+			source, out := s.split(test.conf)
+			if test.change != nil {
+				test.change(out)
+			}
+			report, err := verifySplit(source, out, test.conf.depth, defaultProvenanceNamespace)
+			s.Require().NoError(err)
+			s.Equal(test.verified, report.verified)
+			s.Equal(test.want, report.discrepancies)
+			s.Require().NoError(os.RemoveAll(out))
+		})
+	}
+}
+
+func (s *VerifySuite) TestVerifyCommand() {
+	source, out := s.split(Config{depth: 2})
+	s.NoError(verifyCommand([]string{"-in", source, "-out", out, "-depth", "2"}))
+	s.Require().NoError(os.Remove(filepath.Join(out, "set", "set", "0", "group", "1", "item.0.xml")))
+	s.EqualError(verifyCommand([]string{"-in", source, "-out", out, "-depth", "2"}), "1 discrepancies found between "+source+" and its split output")
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	zipLocalHeaderSignature   = 0x04034b50
+	zipCentralHeaderSignature = 0x02014b50
+	zipEndSignature           = 0x06054b50
+	zip64EndSignature         = 0x06064b50
+	zip64LocatorSignature     = 0x07064b50
+	zipStore                  = 0
+	zipDeflate                = 8
+	zipVersion20              = 20
+	zipVersion45              = 45
+	zipUTF8Flag               = 0x800
+	zipUint16Max              = 0xffff
+	zipUint32Max              = 0xffffffff
+)
+
+// zipWriter streams ioActions into one or more zip archives, deflating each entry individually.
+// archive/zip is not used as its Writer holds a header for every entry, name included, until the
+// archive is closed, in order to write the central directory; with a record per entry an archive of
+// a large source would keep millions of them in memory. Instead the central directory is spooled to
+// a temporary file and appended on close, so memory use stays bounded however many entries there are.
+type zipWriter struct {
+	out       string
+	base      string
+	limit     int64
+	level     int
+	index     int
+	file      *os.File
+	archive   *bufio.Writer
+	offset    uint64
+	directory *os.File
+	central   *bufio.Writer
+	entries   uint64
+	deflater  *flate.Writer
+	buffer    bytes.Buffer
+	listed    outputList
+}
+
+func newZipWriter(conf Config, source string) *zipWriter {
+	return &zipWriter{
+		out:   conf.out,
+		base:  filepath.Join(conf.out, sourceName(source)),
+		limit: conf.rollover,
+		level: conf.compressLevel,
+	}
+}
+
+func (w *zipWriter) write(actions []ioAction) ([]ioAction, error) {
+	for len(actions) > 0 && actions[0].ready {
+		if err := w.rollover(); err != nil {
+			return nil, err
+		}
+		action := actions[0]
+		var err error
+		switch action.actionType {
+		case writeFile:
+			err = w.writeEntry(relativePath(w.out, action.path), []byte(strings.Join(action.lines, "")))
+			if err == nil && !action.envelope && !action.sidecar {
+				w.listed.count(1)
+			}
+		case newDirectory:
+			err = w.writeEntry(relativePath(w.out, action.path)+"/", nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		actions = actions[1:]
+	}
+	return actions, nil
+}
+
+// writeEntry writes the local header and data for a single entry and spools its central directory header.
+// Entries with names ending in a slash are stored as directories.
+func (w *zipWriter) writeEntry(name string, content []byte) error {
+	isDir := strings.HasSuffix(name, "/")
+	method := uint16(zipStore)
+	data := content
+	if !isDir {
+		method = zipDeflate
+		w.buffer.Reset()
+		w.deflater.Reset(&w.buffer)
+		if _, err := w.deflater.Write(content); err != nil {
+			return err
+		}
+		if err := w.deflater.Close(); err != nil {
+			return err
+		}
+		data = w.buffer.Bytes()
+	}
+	if uint64(len(content)) >= zipUint32Max {
+		return fmt.Errorf("zip entry '%s' is too large", name)
+	}
+	modTime, modDate := zipTime(time.Now())
+	crc := crc32.ChecksumIEEE(content)
+
+	// Offsets beyond 4GB are recorded in a zip64 extended information field of the central header,
+	// and both headers give the version needed to extract the entry as the one introducing zip64.
+	var extra []byte
+	offset := uint32(w.offset)
+	version := uint16(zipVersion20)
+	if w.offset >= zipUint32Max {
+		extra = make([]byte, 12)
+		binary.LittleEndian.PutUint16(extra[0:], 0x0001)
+		binary.LittleEndian.PutUint16(extra[2:], 8)
+		binary.LittleEndian.PutUint64(extra[4:], w.offset)
+		offset = zipUint32Max
+		version = zipVersion45
+	}
+
+	local := make([]byte, 30)
+	binary.LittleEndian.PutUint32(local[0:], zipLocalHeaderSignature)
+	binary.LittleEndian.PutUint16(local[4:], version)
+	binary.LittleEndian.PutUint16(local[6:], zipUTF8Flag)
+	binary.LittleEndian.PutUint16(local[8:], method)
+	binary.LittleEndian.PutUint16(local[10:], modTime)
+	binary.LittleEndian.PutUint16(local[12:], modDate)
+	binary.LittleEndian.PutUint32(local[14:], crc)
+	binary.LittleEndian.PutUint32(local[18:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(local[22:], uint32(len(content)))
+	binary.LittleEndian.PutUint16(local[26:], uint16(len(name)))
+	for _, b := range [][]byte{local, []byte(name), data} {
+		if _, err := w.archive.Write(b); err != nil {
+			return err
+		}
+	}
+
+	externalAttrs := uint32(0644) << 16
+	if isDir {
+		externalAttrs = uint32(0755|040000)<<16 | 0x10
+	}
+	central := make([]byte, 46)
+	binary.LittleEndian.PutUint32(central[0:], zipCentralHeaderSignature)
+	binary.LittleEndian.PutUint16(central[4:], 3<<8|zipVersion45)
+	binary.LittleEndian.PutUint16(central[6:], version)
+	binary.LittleEndian.PutUint16(central[8:], zipUTF8Flag)
+	binary.LittleEndian.PutUint16(central[10:], method)
+	binary.LittleEndian.PutUint16(central[12:], modTime)
+	binary.LittleEndian.PutUint16(central[14:], modDate)
+	binary.LittleEndian.PutUint32(central[16:], crc)
+	binary.LittleEndian.PutUint32(central[20:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(central[24:], uint32(len(content)))
+	binary.LittleEndian.PutUint16(central[28:], uint16(len(name)))
+	binary.LittleEndian.PutUint16(central[30:], uint16(len(extra)))
+	binary.LittleEndian.PutUint32(central[38:], externalAttrs)
+	binary.LittleEndian.PutUint32(central[42:], offset)
+	for _, b := range [][]byte{central, []byte(name), extra} {
+		if _, err := w.central.Write(b); err != nil {
+			return err
+		}
+	}
+
+	w.offset += uint64(len(local) + len(name) + len(data))
+	w.entries++
+	return nil
+}
+
+// rollover opens the first archive, or the next one when the current archive has reached the size limit.
+func (w *zipWriter) rollover() error {
+	if w.file != nil && (w.limit <= 0 || int64(w.offset) < w.limit) {
+		return nil
+	}
+	if w.file != nil {
+		if err := w.close(); err != nil {
+			return err
+		}
+		w.index++
+	}
+	if w.deflater == nil {
+		var err error
+		if w.deflater, err = flate.NewWriter(nil, w.level); err != nil {
+			return err
+		}
+	}
+	path := fmt.Sprintf("%s.%d.zip", w.base, w.index)
+	directory, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.cd")
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return discard(directory, err)
+	}
+	stored := newCountingWriter(file)
+	w.listed.open(path, stored)
+	w.file, w.archive = file, bufio.NewWriter(stored)
+	w.directory, w.central = directory, bufio.NewWriter(directory)
+	w.offset, w.entries = 0, 0
+	return nil
+}
+
+func (w *zipWriter) outputs() []output {
+	return w.listed.take()
+}
+
+// close appends the spooled central directory and the end of central directory records.
+func (w *zipWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	defer os.Remove(w.directory.Name())
+	err := w.finish()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := w.directory.Close(); err == nil {
+		err = cerr
+	}
+	w.listed.close()
+	w.file, w.directory = nil, nil
+	return err
+}
+
+// discard closes and removes a file that could not be used because of err, reporting any failure
+// to do so alongside err.
+func discard(file *os.File, err error) error {
+	cerr := file.Close()
+	if rerr := os.Remove(file.Name()); cerr == nil {
+		cerr = rerr
+	}
+	if cerr != nil {
+		return fmt.Errorf("%v (cleaning up %s: %v)", err, file.Name(), cerr)
+	}
+	return err
+}
+
+func (w *zipWriter) finish() error {
+	if err := w.central.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.directory.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	size, err := io.Copy(w.archive, w.directory)
+	if err != nil {
+		return err
+	}
+	if err := w.writeEnd(uint64(size)); err != nil {
+		return err
+	}
+	return w.archive.Flush()
+}
+
+func (w *zipWriter) writeEnd(size uint64) error {
+	start := w.offset
+	entries, cdSize, cdOffset := w.entries, size, start
+	if entries >= zipUint16Max || cdSize >= zipUint32Max || cdOffset >= zipUint32Max {
+		end64 := make([]byte, 56+20)
+		binary.LittleEndian.PutUint32(end64[0:], zip64EndSignature)
+		binary.LittleEndian.PutUint64(end64[4:], 44)
+		binary.LittleEndian.PutUint16(end64[12:], zipVersion45)
+		binary.LittleEndian.PutUint16(end64[14:], zipVersion45)
+		binary.LittleEndian.PutUint64(end64[24:], entries)
+		binary.LittleEndian.PutUint64(end64[32:], entries)
+		binary.LittleEndian.PutUint64(end64[40:], cdSize)
+		binary.LittleEndian.PutUint64(end64[48:], cdOffset)
+		locator := end64[56:]
+		binary.LittleEndian.PutUint32(locator[0:], zip64LocatorSignature)
+		binary.LittleEndian.PutUint64(locator[8:], start+cdSize)
+		binary.LittleEndian.PutUint32(locator[16:], 1)
+		if _, err := w.archive.Write(end64); err != nil {
+			return err
+		}
+		if entries > zipUint16Max {
+			entries = zipUint16Max
+		}
+		if cdSize > zipUint32Max {
+			cdSize = zipUint32Max
+		}
+		if cdOffset > zipUint32Max {
+			cdOffset = zipUint32Max
+		}
+	}
+	end := make([]byte, 22)
+	binary.LittleEndian.PutUint32(end[0:], zipEndSignature)
+	binary.LittleEndian.PutUint16(end[8:], uint16(entries))
+	binary.LittleEndian.PutUint16(end[10:], uint16(entries))
+	binary.LittleEndian.PutUint32(end[12:], uint32(cdSize))
+	binary.LittleEndian.PutUint32(end[16:], uint32(cdOffset))
+	_, err := w.archive.Write(end)
+	return err
+}
+
+// zipTime converts t into MS-DOS time and date fields.
+func zipTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1),
+		uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+}
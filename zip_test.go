@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ZipSuite struct {
+	tempDirSuite
+}
+
+func TestZipSuite(t *testing.T) {
+	suite.Run(t, new(ZipSuite))
+}
+
+func (s *ZipSuite) readArchive(path string) map[string]string {
+	r, err := zip.OpenReader(path)
+	s.Require().NoError(err)
+	defer r.Close()
+	entries := make(map[string]string)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		s.Require().NoError(err)
+		content, err := ioutil.ReadAll(rc)
+		s.Require().NoError(err)
+		rc.Close()
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func (s *ZipSuite) TestWrite() {
+	w := newZipWriter(Config{out: s.dir}, "in/sprot.xml.gz")
+	remaining, err := w.write([]ioAction{
+		{actionType: newDirectory, path: s.dir + "/sprot.xml/uniprot/0", ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot.xml/uniprot/0/root.xml", lines: []string{"<uniprot/>"}, ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot.xml/uniprot/0/entry.0.xml", lines: []string{"<entry>", "a", "</entry>"}, ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot.xml/uniprot/0/entry.1.xml", lines: []string{"<entry>"}},
+	})
+	s.Require().NoError(err)
+	s.Assert().Len(remaining, 1)
+	s.Require().NoError(w.close())
+
+	s.Assert().Equal(map[string]string{
+		"sprot.xml/uniprot/0/":            "",
+		"sprot.xml/uniprot/0/root.xml":    "<uniprot/>",
+		"sprot.xml/uniprot/0/entry.0.xml": "<entry>a</entry>",
+	}, s.readArchive(filepath.Join(s.dir, "sprot.xml.0.zip")))
+
+	leftovers, err := filepath.Glob(filepath.Join(s.dir, "*.cd"))
+	s.Require().NoError(err)
+	s.Assert().Empty(leftovers)
+}
+
+func (s *ZipSuite) TestZip64Entries() {
+	w := newZipWriter(Config{out: s.dir}, "in/many.xml")
+	actions := make([]ioAction, 0, zipUint16Max+10)
+	for i := 0; i < cap(actions); i++ {
+		actions = append(actions, ioAction{actionType: writeFile, path: fmt.Sprintf("%s/many/r.%d.xml", s.dir, i), lines: []string{"<r/>"}, ready: true})
+	}
+	_, err := w.write(actions)
+	s.Require().NoError(err)
+	s.Require().NoError(w.close())
+
+	entries := s.readArchive(filepath.Join(s.dir, "many.0.zip"))
+	s.Assert().Len(entries, cap(actions))
+	for _, i := range []int{0, zipUint16Max, cap(actions) - 1} {
+		s.Assert().Equal("<r/>", entries[fmt.Sprintf("many/r.%d.xml", i)])
+	}
+}
+
+func (s *ZipSuite) TestRollover() {
+	w := newZipWriter(Config{out: s.dir, rollover: 1}, "in/sprot.xml")
+	_, err := w.write([]ioAction{
+		{actionType: writeFile, path: s.dir + "/sprot/a.0.xml", lines: []string{"<a/>"}, ready: true},
+		{actionType: writeFile, path: s.dir + "/sprot/a.1.xml", lines: []string{"<a/>"}, ready: true},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(w.close())
+
+	s.Assert().Equal(map[string]string{"sprot/a.0.xml": "<a/>"}, s.readArchive(filepath.Join(s.dir, "sprot.0.zip")))
+	s.Assert().Equal(map[string]string{"sprot/a.1.xml": "<a/>"}, s.readArchive(filepath.Join(s.dir, "sprot.1.zip")))
+}
+
+func (s *ZipSuite) TestMissingOutputFolder() {
+	out := filepath.Join(s.dir, "missing", "out")
+	w, err := newWriter(Config{out: out, archive: "zip"}, "in/sprot.xml")
+	s.Require().NoError(err)
+	_, err = w.write([]ioAction{{actionType: writeFile, path: out + "/sprot/a.0.xml", lines: []string{"<a/>"}, ready: true}})
+	s.Require().NoError(err)
+	s.Require().NoError(closeWriter(w))
+
+	s.Assert().Equal(map[string]string{"sprot/a.0.xml": "<a/>"}, s.readArchive(filepath.Join(out, "sprot.0.zip")))
+}
+
+func (s *ZipSuite) TestCompressLevel() {
+	content := strings.Repeat("<entry>a</entry>", 100)
+	sizes := make(map[int]int64)
+	for _, level := range []int{flate.NoCompression, flate.BestCompression} {
+		w := newZipWriter(Config{out: s.dir, compressLevel: level}, fmt.Sprintf("in/level%d.xml", level))
+		_, err := w.write([]ioAction{{actionType: writeFile, path: s.dir + "/level/a.0.xml", lines: []string{content}, ready: true}})
+		s.Require().NoError(err)
+		s.Require().NoError(w.close())
+		path := filepath.Join(s.dir, fmt.Sprintf("level%d.0.zip", level))
+		s.Assert().Equal(map[string]string{"level/a.0.xml": content}, s.readArchive(path))
+		info, err := os.Stat(path)
+		s.Require().NoError(err)
+		sizes[level] = info.Size()
+	}
+	s.Assert().Greater(sizes[flate.NoCompression], int64(len(content)))
+	s.Assert().Less(sizes[flate.BestCompression], int64(len(content)))
+}
+
+func (s *ZipSuite) TestZip64HeaderVersions() {
+	w := newZipWriter(Config{out: s.dir}, "in/big.xml")
+	s.Require().NoError(w.rollover())
+	// The entry is written as if 4GB of entries came before it.
+	w.offset = zipUint32Max
+	s.Require().NoError(w.writeEntry("big/a.0.xml", []byte("<a/>")))
+	s.Require().NoError(w.close())
+
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, "big.0.zip"))
+	s.Require().NoError(err)
+	s.Assert().Equal(uint16(zipVersion45), binary.LittleEndian.Uint16(content[4:]))
+	central := bytes.Index(content, []byte{0x50, 0x4b, 0x01, 0x02})
+	s.Require().True(central > 0)
+	s.Assert().Equal(uint16(zipVersion45), binary.LittleEndian.Uint16(content[central+6:]))
+}